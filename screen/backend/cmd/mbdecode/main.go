@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/coorify/be/modbus"
+)
+
+func parser(mode string) (modbus.Parser, error) {
+	switch mode {
+	case "rtu":
+		return modbus.NewRTUParser(), nil
+	case "ascii":
+		return modbus.NewASCIIParser(), nil
+	case "tcp":
+		return modbus.NewTCPParser(), nil
+	}
+
+	return nil, fmt.Errorf("unknown mode %q", mode)
+}
+
+func decode(r io.Reader, w io.Writer, prs modbus.Parser) error {
+	dec := modbus.NewCaptureDecoder(prs)
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		rec := &modbus.CaptureRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		frames, err := dec.Feed(rec)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		for _, f := range frames {
+			fmt.Fprintln(w, f.String())
+		}
+	}
+
+	for _, f := range dec.Flush() {
+		fmt.Fprintln(w, f.String())
+	}
+
+	return scanner.Err()
+}
+
+func main() {
+	mode := flag.String("mode", "rtu", "framing of the capture: rtu, ascii or tcp")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-mode rtu|ascii|tcp] [capture.jsonl]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	prs, err := parser(*mode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	in := os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if err := decode(in, os.Stdout, prs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
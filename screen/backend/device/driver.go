@@ -9,6 +9,12 @@ import (
 
 var ErrPortNotOpen = errors.New("port not open")
 
+const DEFAULT_BAUDRATE = 115200
+
+// USB serial adapters hand data over in chunks 1 to 16 ms apart, so a
+// shorter gap on the line says nothing about where a frame ends.
+const USB_LATENCY = 20 * time.Millisecond
+
 type Driver struct {
 	name string
 	port serial.Port
@@ -20,7 +26,7 @@ func NewDriver(name string) *Driver {
 		name: name,
 		port: nil,
 		mode: &serial.Mode{
-			BaudRate: 115200,
+			BaudRate: DEFAULT_BAUDRATE,
 			DataBits: 8,
 			Parity:   serial.NoParity,
 			StopBits: serial.OneStopBit,
@@ -50,6 +56,35 @@ func (m *Driver) Close() error {
 	return m.port.Close()
 }
 
+func (m *Driver) BaudRate() int {
+	return m.mode.BaudRate
+}
+
+// SetBaudRate changes the baud rate, reconfiguring the port right away when
+// it is open and on the next Open otherwise.
+func (m *Driver) SetBaudRate(baud int) error {
+	m.mode.BaudRate = baud
+	if m.port == nil {
+		return nil
+	}
+
+	return m.port.SetMode(m.mode)
+}
+
+// SilenceFloor keeps modbus from taking the gaps between USB transfers for
+// the end of a frame. The port is always found on USB, see WaitPort.
+func (m *Driver) SilenceFloor() time.Duration {
+	return USB_LATENCY
+}
+
+func (m *Driver) SetReadTimeout(t time.Duration) error {
+	if m.port == nil {
+		return ErrPortNotOpen
+	}
+
+	return m.port.SetReadTimeout(t)
+}
+
 func (m *Driver) Read(p []byte) (int, error) {
 	if m.port == nil {
 		return 0, ErrPortNotOpen
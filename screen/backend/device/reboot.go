@@ -2,7 +2,15 @@ package device
 
 import "time"
 
-func Reboot(drv *Driver, download bool) {
+// Resetter is a port whose DTR and RTS lines drive EN and IO0 of the chip.
+type Resetter interface {
+	Open() error
+	Close() error
+	SetDTR(bool) error
+	SetRTS(bool) error
+}
+
+func Reboot(drv Resetter, download bool) {
 	drv.Open()
 
 	if download {
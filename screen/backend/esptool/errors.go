@@ -0,0 +1,94 @@
+package esptool
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ESPERR_INVALIDMESSAGE = 0x05
+	ESPERR_FAILEDTOACT    = 0x06
+	ESPERR_INVALIDCRC     = 0x07
+	ESPERR_FLASHWRITE     = 0x08
+	ESPERR_FLASHREAD      = 0x09
+	ESPERR_FLASHREADLEN   = 0x0a
+	ESPERR_DEFLATE        = 0x0b
+
+	ESPERR_BADDATALEN      = 0xc0
+	ESPERR_BADDATACHECKSUM = 0xc1
+	ESPERR_BADBLOCKSIZE    = 0xc2
+	ESPERR_INVALIDCOMMAND  = 0xc3
+	ESPERR_FAILEDSPIOP     = 0xc4
+	ESPERR_FAILEDSPIUNLOCK = 0xc5
+	ESPERR_NOTINFLASHMODE  = 0xc6
+	ESPERR_INFLATE         = 0xc7
+	ESPERR_NOTENOUGHDATA   = 0xc8
+	ESPERR_TOOMUCHDATA     = 0xc9
+	ESPERR_NOTIMPLEMENTED  = 0xff
+)
+
+var (
+	ErrInvalidMessage = errors.New("esptool: received message is invalid")
+	ErrFailedToAct    = errors.New("esptool: failed to act on received message")
+	ErrInvalidCRC     = errors.New("esptool: invalid crc in message")
+	ErrFlashWrite     = errors.New("esptool: flash write error")
+	ErrFlashRead      = errors.New("esptool: flash read error")
+	ErrFlashReadLen   = errors.New("esptool: flash read length error")
+	ErrDeflate        = errors.New("esptool: deflate error")
+
+	ErrBadDataLen      = errors.New("esptool: bad data length")
+	ErrBadDataChecksum = errors.New("esptool: bad data checksum")
+	ErrBadBlockSize    = errors.New("esptool: bad blocksize")
+	ErrInvalidCommand  = errors.New("esptool: invalid command")
+	ErrFailedSPIOp     = errors.New("esptool: failed spi operation")
+	ErrFailedSPIUnlock = errors.New("esptool: failed spi unlock")
+	ErrNotInFlashMode  = errors.New("esptool: not in flash mode")
+	ErrInflate         = errors.New("esptool: inflate error")
+	ErrNotEnoughData   = errors.New("esptool: not enough data")
+	ErrTooMuchData     = errors.New("esptool: too much data")
+	ErrNotImplemented  = errors.New("esptool: command not implemented")
+
+	ErrNeedStub = errors.New("esptool: command needs the flasher stub")
+)
+
+var statusErrors = map[uint8]error{
+	ESPERR_INVALIDMESSAGE: ErrInvalidMessage,
+	ESPERR_FAILEDTOACT:    ErrFailedToAct,
+	ESPERR_INVALIDCRC:     ErrInvalidCRC,
+	ESPERR_FLASHWRITE:     ErrFlashWrite,
+	ESPERR_FLASHREAD:      ErrFlashRead,
+	ESPERR_FLASHREADLEN:   ErrFlashReadLen,
+	ESPERR_DEFLATE:        ErrDeflate,
+
+	ESPERR_BADDATALEN:      ErrBadDataLen,
+	ESPERR_BADDATACHECKSUM: ErrBadDataChecksum,
+	ESPERR_BADBLOCKSIZE:    ErrBadBlockSize,
+	ESPERR_INVALIDCOMMAND:  ErrInvalidCommand,
+	ESPERR_FAILEDSPIOP:     ErrFailedSPIOp,
+	ESPERR_FAILEDSPIUNLOCK: ErrFailedSPIUnlock,
+	ESPERR_NOTINFLASHMODE:  ErrNotInFlashMode,
+	ESPERR_INFLATE:         ErrInflate,
+	ESPERR_NOTENOUGHDATA:   ErrNotEnoughData,
+	ESPERR_TOOMUCHDATA:     ErrTooMuchData,
+	ESPERR_NOTIMPLEMENTED:  ErrNotImplemented,
+}
+
+// StatusError is a command the ROM or stub answered with a failure status.
+// It unwraps to the matching Err value, so callers can use errors.Is.
+type StatusError struct {
+	Op   uint8
+	Code uint8
+}
+
+func (e *StatusError) Error() string {
+	text := "unknown"
+	if err := e.Unwrap(); err != nil {
+		text = strings.TrimPrefix(err.Error(), "esptool: ")
+	}
+	return fmt.Sprintf("esptool: command 0x%02X error 0x%02X (%s)", e.Op, e.Code, text)
+}
+
+func (e *StatusError) Unwrap() error {
+	return statusErrors[e.Code]
+}
@@ -3,6 +3,9 @@ package esptool
 import (
 	"bytes"
 	"compress/zlib"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -28,19 +31,37 @@ const (
 	ESPOP_MEMDATA        = 0x07
 	ESPOP_SYNC           = 0x08
 	ESPOP_READREG        = 0x0a
+	ESPOP_SPIATTACH      = 0x0d
+	ESPOP_CHANGEBAUDRATE = 0x0f
 	ESPOP_FLASHDEFLBEGIN = 0x10
 	ESPOP_FLASHDEFLDATA  = 0x11
 	ESPOP_FLASHDEFLEND   = 0x12
+	ESPOP_FLASHMD5       = 0x13
+	ESPOP_SECURITYINFO   = 0x14
 	ESPOP_ERASEFLASH     = 0xd0
+	ESPOP_READFLASH      = 0xd2
 
-	ESP_RAMBLOCK   = 0x1800
-	ESP_FLASHBLOCK = 0x400
+	ESP_RAMBLOCK     = 0x1800
+	ESP_FLASHBLOCK   = 0x400
+	ESP_FLASHSECTOR  = 0x1000
+	ESP_READINFLIGHT = 64
+
+	ESP_MAGIC_REG  = 0x40001000
+	ESP_IMAGEMAGIC = 0xE9
 )
 
+// baudDriver is implemented by drivers that can change their baud rate
+// while open.
+type baudDriver interface {
+	BaudRate() int
+	SetBaudRate(int) error
+}
+
 type Loader struct {
-	efs fs.FS
-	drv Driver
-	rom target.ROM
+	efs  fs.FS
+	drv  Driver
+	rom  target.ROM
+	stub bool
 }
 
 func NewLoader(drv Driver, embedFS fs.FS) *Loader {
@@ -63,23 +84,93 @@ func (l *Loader) Open() error {
 		return err
 	}
 
-	val, err := l.ReadReg(0x40001000)
+	if err := l.detect(); err != nil {
+		return err
+	}
+
+	mac, err := l.ReadMac()
+	if err != nil {
+		return err
+	}
+	logrus.Infof("esptool: chip %s mac(%s)", l.rom.Name(), mac)
+
+	if err := l.RunStub(); err != nil {
+		if !errors.Is(err, target.ErrNoStub) {
+			return err
+		}
+
+		logrus.Warnf("%v, staying on the ROM loader", err)
+		return l.SpiAttach()
+	}
+
+	return nil
+}
+
+// ROM is the chip detected by Open.
+func (l *Loader) ROM() target.ROM {
+	return l.rom
+}
+
+// Stub reports whether the flasher stub is running, as opposed to the ROM
+// loader, which lacks READ_FLASH and ERASE_FLASH.
+func (l *Loader) Stub() bool {
+	return l.stub
+}
+
+// SpiAttach connects the SPI flash. The stub does this when it starts, the
+// ROM loader has to be told and wants one more zero word than the stub.
+func (l *Loader) SpiAttach() error {
+	pkt := make([]byte, 0)
+	pkt = append(pkt, uint32ToBytes(0)...)
+	if !l.stub {
+		pkt = append(pkt, uint32ToBytes(0)...)
+	}
+
+	_, _, err := l.exec(ESPOP_SPIATTACH, pkt, 0, time.Second)
+	return err
+}
+
+// detect prefers the chip_id from GET_SECURITY_INFO and falls back to the
+// magic register for the ESP32 and older ROMs that lack it.
+func (l *Loader) detect() error {
+	if id, err := l.ChipID(); err == nil {
+		l.rom = target.ChipIDToRom(id)
+		if l.rom != nil {
+			return nil
+		}
+	}
+
+	val, err := l.ReadReg(ESP_MAGIC_REG)
 	if err != nil {
 		return err
 	}
 
 	l.rom = target.MagicToRom(val)
 	if l.rom == nil {
-		return fmt.Errorf("esptool: chip not support")
+		return fmt.Errorf("esptool: chip not support, magic 0x%08x", val)
 	}
 
-	mac, err := l.ReadMac()
+	return nil
+}
+
+func (l *Loader) SecurityInfo() ([]byte, error) {
+	_, data, err := l.exec(ESPOP_SECURITYINFO, nil, 0, time.Second)
+	return data, err
+}
+
+// ChipID returns the chip_id field of GET_SECURITY_INFO, which only the
+// 20 byte form of the reply carries.
+func (l *Loader) ChipID() (uint32, error) {
+	data, err := l.SecurityInfo()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	logrus.Infof("esptool: chip mac(%s)", mac)
 
-	return l.RunStub()
+	if len(data) < 20 {
+		return 0, fmt.Errorf("esptool: security info without chip id")
+	}
+
+	return bytesToUint32(data[12:16]), nil
 }
 
 func (l *Loader) Sync(retryMax int) error {
@@ -113,6 +204,54 @@ func (l *Loader) ReadReg(addr uint32) (uint32, error) {
 	return val, err
 }
 
+// ChangeBaudRate switches the chip and then the driver to baud, and checks
+// the link still works. The stub wants the current rate along with the new
+// one, the ROM wants zero there.
+func (l *Loader) ChangeBaudRate(baud int) error {
+	drv, ok := l.drv.(baudDriver)
+	if !ok {
+		return fmt.Errorf("esptool: driver can not change baud rate")
+	}
+
+	old := 0
+	if l.stub {
+		old = drv.BaudRate()
+	}
+
+	pkt := make([]byte, 0)
+	pkt = append(pkt, uint32ToBytes(uint32(baud))...)
+	pkt = append(pkt, uint32ToBytes(uint32(old))...)
+	if _, _, err := l.exec(ESPOP_CHANGEBAUDRATE, pkt, 0, time.Second); err != nil {
+		return err
+	}
+
+	if err := drv.SetBaudRate(baud); err != nil {
+		return err
+	}
+
+	// Let the chip settle on the new rate and drop what was garbled on the way.
+	time.Sleep(50 * time.Millisecond)
+	l.flush()
+
+	if _, err := l.ReadReg(ESP_MAGIC_REG); err != nil {
+		return fmt.Errorf("esptool: no response at %d baud: %w", baud, err)
+	}
+
+	logrus.Infof("esptool: changed baud rate to %d", baud)
+	return nil
+}
+
+// flush drains whatever the driver has buffered.
+func (l *Loader) flush() {
+	buf := make([]byte, 256)
+	for {
+		n, err := l.drv.Read(buf)
+		if n == 0 || err != nil {
+			return
+		}
+	}
+}
+
 func (l *Loader) ReadFile(name string) ([]byte, error) {
 	f, err := l.efs.Open(name)
 	if err != nil {
@@ -124,6 +263,10 @@ func (l *Loader) ReadFile(name string) ([]byte, error) {
 }
 
 func (l *Loader) EraseFlash() error {
+	if !l.stub {
+		return ErrNeedStub
+	}
+
 	logrus.Info("esptool: erasing flash (this may take a while)...")
 	_, _, err := l.exec(ESPOP_ERASEFLASH, make([]byte, 0), 0, 20*time.Second)
 	if err == nil {
@@ -132,6 +275,110 @@ func (l *Loader) EraseFlash() error {
 	return err
 }
 
+// ReadFlash streams size bytes of flash from addr into w. The stub sends
+// sector sized packets, expects each one acknowledged with the running
+// total and finishes with the MD5 of everything it sent.
+func (l *Loader) ReadFlash(addr uint32, size uint32, w io.Writer) error {
+	if !l.stub {
+		return ErrNeedStub
+	}
+
+	pkt := make([]byte, 0)
+	pkt = append(pkt, uint32ToBytes(addr)...)
+	pkt = append(pkt, uint32ToBytes(size)...)
+	pkt = append(pkt, uint32ToBytes(ESP_FLASHSECTOR)...)
+	pkt = append(pkt, uint32ToBytes(ESP_READINFLIGHT)...)
+
+	if _, _, err := l.exec(ESPOP_READFLASH, pkt, 0, time.Second); err != nil {
+		return err
+	}
+
+	hash := md5.New()
+	recv := uint32(0)
+	for recv < size {
+		data, err := SlipRead(l.drv, 10*time.Second)
+		if err != nil {
+			return err
+		}
+
+		recv += uint32(len(data))
+		if recv > size || (recv < size && len(data) < ESP_FLASHSECTOR) {
+			return fmt.Errorf("esptool: read flash: corrupt packet of %d bytes at %d", len(data), recv)
+		}
+
+		hash.Write(data)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+
+		if err := SlipWrite(l.drv, uint32ToBytes(recv)); err != nil {
+			return err
+		}
+
+		logrus.Debugf("esptool: read %d of %d - %.2f", recv, size, float64(recv)/float64(size)*100.0)
+	}
+
+	digest, err := SlipRead(l.drv, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(digest, hash.Sum(nil)) {
+		return fmt.Errorf("esptool: read flash: digest mismatch")
+	}
+
+	return nil
+}
+
+// FlashSize reads the size the bootloader image header was built for.
+func (l *Loader) FlashSize() (uint32, error) {
+	header := &bytes.Buffer{}
+	if err := l.ReadFlash(l.rom.BootloaderAddr(), 16, header); err != nil {
+		return 0, err
+	}
+
+	raws := header.Bytes()
+	if raws[0] != ESP_IMAGEMAGIC {
+		return 0, fmt.Errorf("esptool: no bootloader image header")
+	}
+
+	code := raws[3] >> 4
+	if code > 7 {
+		return 0, fmt.Errorf("esptool: unknown flash size code %d", code)
+	}
+
+	return (1024 * 1024) << code, nil
+}
+
+// FlashMD5 returns the MD5 of size bytes of flash at addr. The stub answers
+// with the raw digest, the ROM with its 32 character hex form.
+func (l *Loader) FlashMD5(addr uint32, size uint32) ([]byte, error) {
+	pkt := make([]byte, 0)
+	pkt = append(pkt, uint32ToBytes(addr)...)
+	pkt = append(pkt, uint32ToBytes(size)...)
+	pkt = append(pkt, uint32ToBytes(0)...)
+	pkt = append(pkt, uint32ToBytes(0)...)
+
+	// Hashing runs at roughly 8 seconds per megabyte on the ROM.
+	timeout := 3*time.Second + time.Duration(size)*8*time.Second/(1024*1024)
+	_, data, err := l.exec(ESPOP_FLASHMD5, pkt, 0, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) >= 32 {
+		if digest, err := hex.DecodeString(string(data[:32])); err == nil {
+			return digest, nil
+		}
+	}
+
+	if len(data) < md5.Size {
+		return nil, fmt.Errorf("esptool: flash md5: short reply of %d bytes", len(data))
+	}
+
+	return data[:md5.Size], nil
+}
+
 func (l *Loader) WriteFlash(addr uint32, image []byte) error {
 	var b bytes.Buffer
 	w, _ := zlib.NewWriterLevel(&b, 9)
@@ -169,17 +416,41 @@ func (l *Loader) WriteFlashFinish() error {
 	return nil
 }
 
+// FlashDeflBegin takes the uncompressed size. The stub erases as it goes,
+// the ROM erases the whole region up front, rounded up to whole blocks.
 func (l *Loader) FlashDeflBegin(eraseSize uint32, numBlocks uint32, blockSize uint32, offset uint32) error {
+	timeout := time.Second
+	if !l.stub {
+		eraseSize = (eraseSize + blockSize - 1) / blockSize * blockSize
+		timeout = eraseTimeout(eraseSize)
+	}
+
 	pkt := make([]byte, 0)
 	pkt = append(pkt, uint32ToBytes(eraseSize)...)
 	pkt = append(pkt, uint32ToBytes(numBlocks)...)
 	pkt = append(pkt, uint32ToBytes(blockSize)...)
 	pkt = append(pkt, uint32ToBytes(offset)...)
+	pkt = l.encrypted(pkt)
 
-	_, _, err := l.exec(ESPOP_FLASHDEFLBEGIN, pkt, 0, time.Second)
+	_, _, err := l.exec(ESPOP_FLASHDEFLBEGIN, pkt, 0, timeout)
 	return err
 }
 
+// encrypted appends the "not encrypted" word the ROM loaders of the newer
+// chips expect after the FLASH_BEGIN parameters.
+func (l *Loader) encrypted(pkt []byte) []byte {
+	if l.stub || !l.rom.EncryptedFlash() {
+		return pkt
+	}
+
+	return append(pkt, uint32ToBytes(0)...)
+}
+
+// eraseTimeout allows for the ROM erasing at roughly 30 seconds per megabyte.
+func eraseTimeout(size uint32) time.Duration {
+	return 3*time.Second + time.Duration(size)*30*time.Second/(1024*1024)
+}
+
 func (l *Loader) FlashDeflFinish(reboot bool) error {
 	isReboot := 1
 	if reboot {
@@ -201,8 +472,14 @@ func (l *Loader) FlashBegin(size uint32, addr uint32) error {
 	pkt = append(pkt, uint32ToBytes(numBlocks)...)
 	pkt = append(pkt, uint32ToBytes(ESP_FLASHBLOCK)...)
 	pkt = append(pkt, uint32ToBytes(addr)...)
+	pkt = l.encrypted(pkt)
 
-	_, _, err := l.exec(ESPOP_FLASHBEGIN, pkt, 0, time.Second)
+	timeout := time.Second
+	if !l.stub {
+		timeout = eraseTimeout(eraseSize)
+	}
+
+	_, _, err := l.exec(ESPOP_FLASHBEGIN, pkt, 0, timeout)
 	return err
 }
 
@@ -235,37 +512,25 @@ func (l *Loader) MemFinish(entry uint32) error {
 }
 
 func (l *Loader) RunStub() error {
-	var addr, blen, blocks uint32
-	var raws []byte
+	stub, err := l.rom.Stub(l)
+	if err != nil {
+		return fmt.Errorf("esptool: %s: %w", l.rom.Name(), err)
+	}
 
 	logrus.Info("esptool: uploading stub...")
 
-	addr, raws = l.rom.StubText(l)
-	blen = uint32(len(raws))
-	blocks = (blen + ESP_RAMBLOCK - 1) / ESP_RAMBLOCK
-
-	if err := l.MemBegin(blen, uint32(blocks), ESP_RAMBLOCK, addr); err != nil {
-		return err
-	}
-	if err := l.MemBlock(blocks, ESP_RAMBLOCK, raws); err != nil {
+	if err := l.upload(stub.TextStart, stub.Text); err != nil {
 		return err
 	}
 
-	addr, raws = l.rom.StubData(l)
-	blen = uint32(len(raws))
-	blocks = (blen + ESP_RAMBLOCK - 1) / ESP_RAMBLOCK
-	if err := l.MemBegin(blen, uint32(blocks), ESP_RAMBLOCK, addr); err != nil {
-		return err
-	}
-	if err := l.MemBlock(blocks, ESP_RAMBLOCK, raws); err != nil {
+	if err := l.upload(stub.DataStart, stub.Data); err != nil {
 		return err
 	}
 
 	logrus.Info("esptool: running stub...")
-	addr = l.rom.StubEntry()
 
-	if err := l.MemFinish(addr); err != nil {
-		return nil
+	if err := l.MemFinish(stub.Entry); err != nil {
+		return err
 	}
 
 	time.Sleep(100 * time.Microsecond)
@@ -277,12 +542,28 @@ func (l *Loader) RunStub() error {
 
 	if res[0] == 79 && res[1] == 72 && res[2] == 65 && res[3] == 73 {
 		logrus.Info("esptool: stub running...")
+		l.stub = true
 		return nil
 	}
 
 	return fmt.Errorf("esptool: failed to start stub")
 }
 
+func (l *Loader) upload(addr uint32, raws []byte) error {
+	if len(raws) == 0 {
+		return nil
+	}
+
+	blen := uint32(len(raws))
+	blocks := (blen + ESP_RAMBLOCK - 1) / ESP_RAMBLOCK
+
+	if err := l.MemBegin(blen, blocks, ESP_RAMBLOCK, addr); err != nil {
+		return err
+	}
+
+	return l.MemBlock(blocks, ESP_RAMBLOCK, raws)
+}
+
 func (l *Loader) exec(op byte, data []byte, cks uint32, timeout time.Duration) (uint32, []byte, error) {
 	if data == nil {
 		data = make([]byte, 0)
@@ -313,16 +594,42 @@ func (l *Loader) exec(op byte, data []byte, cks uint32, timeout time.Duration) (
 			return 0, nil, err
 		}
 
-		if replyBytes[1] != byte(op) {
+		if len(replyBytes) < 8 || replyBytes[1] != byte(op) {
 			continue
-		} else {
-			return bytesToUint32(replyBytes[4:8]), replyBytes[8:], nil
 		}
+
+		val, data := bytesToUint32(replyBytes[4:8]), replyBytes[8:]
+		status, data, err := l.status(op, data)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if status[0] != 0 {
+			return 0, nil, &StatusError{Op: op, Code: status[1]}
+		}
+
+		return val, data, nil
 	}
 
 	return 0, nil, fmt.Errorf("esptool: slip timeout")
 }
 
+// status splits the trailing status bytes off a response. The stub sends
+// two, the ROMs of the ESP32 family four, of which only the first two are
+// used: a failure flag followed by the error code.
+func (l *Loader) status(op byte, data []byte) ([]byte, []byte, error) {
+	n := 4
+	if l.stub {
+		n = 2
+	}
+
+	if len(data) < n {
+		return nil, nil, fmt.Errorf("esptool: command 0x%02X: response without status", op)
+	}
+
+	return data[len(data)-n:], data[:len(data)-n], nil
+}
+
 func (l *Loader) block(op byte, blocks uint32, blocksize uint32, bytes []byte) error {
 	sequence := uint32(0)
 	sent := uint32(0)
@@ -0,0 +1,363 @@
+package esptool
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeDriver answers with whatever responses were queued up front.
+type pipeDriver struct {
+	sent    bytes.Buffer
+	replies bytes.Buffer
+}
+
+func (d *pipeDriver) Open() error  { return nil }
+func (d *pipeDriver) Close() error { return nil }
+
+func (d *pipeDriver) Read(p []byte) (int, error)  { return d.replies.Read(p) }
+func (d *pipeDriver) Write(p []byte) (int, error) { return d.sent.Write(p) }
+
+// respond queues a response to op carrying val and data, status included.
+func (d *pipeDriver) respond(op byte, val uint32, data []byte) {
+	pkt := []byte{0x01, op}
+	pkt = append(pkt, uint16ToBytes(uint16(len(data)))...)
+	pkt = append(pkt, uint32ToBytes(val)...)
+	pkt = append(pkt, data...)
+	SlipWrite(&d.replies, pkt)
+}
+
+func TestExecStatus(t *testing.T) {
+	tests := []struct {
+		name  string
+		stub  bool
+		reply []byte
+		val   uint32
+		data  []byte
+		code  uint8
+		err   error
+	}{
+		{"stub ok", true, []byte{0x00, 0x00}, 0x12345678, []byte{}, 0, nil},
+		{"stub ok with data", true, []byte{0xaa, 0xbb, 0x00, 0x00}, 0, []byte{0xaa, 0xbb}, 0, nil},
+		{"rom ok", false, []byte{0x00, 0x00, 0x00, 0x00}, 0x12345678, []byte{}, 0, nil},
+		{"rom ok with data", false, []byte{0xaa, 0xbb, 0x00, 0x00, 0x00, 0x00}, 0, []byte{0xaa, 0xbb}, 0, nil},
+		{"rom invalid message", false, []byte{0x01, 0x05, 0x00, 0x00}, 0, nil, 0x05, ErrInvalidMessage},
+		{"rom failed to act", false, []byte{0x01, 0x06, 0x00, 0x00}, 0, nil, 0x06, ErrFailedToAct},
+		{"rom invalid crc", false, []byte{0x01, 0x07, 0x00, 0x00}, 0, nil, 0x07, ErrInvalidCRC},
+		{"rom deflate", false, []byte{0x01, 0x0b, 0x00, 0x00}, 0, nil, 0x0b, ErrDeflate},
+		{"stub bad checksum", true, []byte{0x01, 0xc1}, 0, nil, 0xc1, ErrBadDataChecksum},
+		{"stub failed spi op", true, []byte{0x01, 0xc4}, 0, nil, 0xc4, ErrFailedSPIOp},
+		{"stub inflate", true, []byte{0x01, 0xc7}, 0, nil, 0xc7, ErrInflate},
+		{"stub too much data", true, []byte{0x01, 0xc9}, 0, nil, 0xc9, ErrTooMuchData},
+		{"stub not implemented", true, []byte{0x01, 0xff}, 0, nil, 0xff, ErrNotImplemented},
+		{"stub unknown code", true, []byte{0x01, 0x42}, 0, nil, 0x42, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &pipeDriver{}
+			d.respond(ESPOP_FLASHDEFLDATA, tc.val, tc.reply)
+			l := &Loader{drv: d, stub: tc.stub}
+
+			val, data, err := l.exec(ESPOP_FLASHDEFLDATA, nil, 0, time.Second)
+			if tc.code == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if val != tc.val || !bytes.Equal(data, tc.data) {
+					t.Errorf("got 0x%08x % x, want 0x%08x % x", val, data, tc.val, tc.data)
+				}
+				return
+			}
+
+			serr := &StatusError{}
+			if !errors.As(err, &serr) {
+				t.Fatalf("got %v, want a StatusError", err)
+			}
+
+			if serr.Op != ESPOP_FLASHDEFLDATA || serr.Code != tc.code {
+				t.Errorf("got op 0x%02X code 0x%02X, want op 0x%02X code 0x%02X", serr.Op, serr.Code, ESPOP_FLASHDEFLDATA, tc.code)
+			}
+
+			if errors.Unwrap(err) != tc.err || tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("got %v, want %v", errors.Unwrap(err), tc.err)
+			}
+		})
+	}
+}
+
+func TestExecShortStatus(t *testing.T) {
+	tests := []struct {
+		name  string
+		stub  bool
+		reply []byte
+	}{
+		{"stub", true, []byte{0x00}},
+		{"rom", false, []byte{0x00, 0x00, 0x00}},
+		{"empty", false, []byte{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &pipeDriver{}
+			d.respond(ESPOP_READREG, 0, tc.reply)
+			l := &Loader{drv: d, stub: tc.stub}
+
+			_, _, err := l.exec(ESPOP_READREG, nil, 0, time.Second)
+			if err == nil || !strings.Contains(err.Error(), "response without status") {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
+
+// Responses to another command, left over from an earlier one, are skipped.
+func TestExecSkipsOtherOps(t *testing.T) {
+	d := &pipeDriver{}
+	d.respond(ESPOP_SYNC, 0, []byte{0x01, 0x05, 0x00, 0x00})
+	d.respond(ESPOP_READREG, 0xdeadbeef, []byte{0x00, 0x00, 0x00, 0x00})
+	l := &Loader{drv: d}
+
+	val, err := l.ReadReg(ESP_MAGIC_REG)
+	if err != nil || val != 0xdeadbeef {
+		t.Errorf("got 0x%08x, %v", val, err)
+	}
+}
+
+func TestStatusErrorText(t *testing.T) {
+	tests := []struct {
+		err  *StatusError
+		text string
+	}{
+		{&StatusError{Op: 0x11, Code: 0xc1}, "esptool: command 0x11 error 0xC1 (bad data checksum)"},
+		{&StatusError{Op: 0x02, Code: 0x05}, "esptool: command 0x02 error 0x05 (received message is invalid)"},
+		{&StatusError{Op: 0xd2, Code: 0x42}, "esptool: command 0xD2 error 0x42 (unknown)"},
+	}
+
+	for _, tc := range tests {
+		if got := tc.err.Error(); got != tc.text {
+			t.Errorf("got %q, want %q", got, tc.text)
+		}
+	}
+}
+
+// sentFrames decodes the SLIP frames written to d so far.
+func sentFrames(t *testing.T, d *pipeDriver) [][]byte {
+	t.Helper()
+
+	var frames [][]byte
+	for d.sent.Len() > 0 {
+		f, err := SlipRead(&d.sent, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func TestReadFlash(t *testing.T) {
+	// 0xC0 and 0xDB have to survive SLIP escaping.
+	image := bytes.Repeat([]byte{0x12, 0xC0, 0xDB, 0x34}, 0x600)
+	sum := md5.Sum(image)
+	long := append(append([]byte{}, image[0x1000:]...), 0x00)
+
+	tests := []struct {
+		name    string
+		stub    bool
+		packets [][]byte
+		digest  []byte
+		acks    []uint32
+		err     string
+	}{
+		{"two sectors", true, [][]byte{image[:0x1000], image[0x1000:]}, sum[:], []uint32{0x1000, 0x1800}, ""},
+		{"digest mismatch", true, [][]byte{image[:0x1000], image[0x1000:]}, make([]byte, md5.Size), []uint32{0x1000, 0x1800}, "digest mismatch"},
+		{"short packet", true, [][]byte{image[:0x800]}, nil, nil, "corrupt packet"},
+		{"past the end", true, [][]byte{image[:0x1000], long}, nil, []uint32{0x1000}, "corrupt packet"},
+		{"rom", false, nil, nil, nil, ErrNeedStub.Error()},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &pipeDriver{}
+			d.respond(ESPOP_READFLASH, 0, []byte{0x00, 0x00})
+			for _, p := range tc.packets {
+				SlipWrite(&d.replies, p)
+			}
+			if tc.digest != nil {
+				SlipWrite(&d.replies, tc.digest)
+			}
+			l := &Loader{drv: d, stub: tc.stub}
+
+			out := &bytes.Buffer{}
+			err := l.ReadFlash(0x1000, uint32(len(image)), out)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got %v, want %q", err, tc.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(out.Bytes(), image) {
+				t.Errorf("read %d bytes, not the image", out.Len())
+			}
+
+			frames := sentFrames(t, d)
+			if !tc.stub {
+				if len(frames) != 0 {
+					t.Errorf("sent % x", frames)
+				}
+				return
+			}
+
+			cmd := frames[0]
+			if cmd[1] != ESPOP_READFLASH || !bytes.Equal(cmd[8:], []byte{
+				0x00, 0x10, 0x00, 0x00, 0x00, 0x18, 0x00, 0x00,
+				0x00, 0x10, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00,
+			}) {
+				t.Errorf("command % x", cmd)
+			}
+
+			var acks []uint32
+			for _, f := range frames[1:] {
+				acks = append(acks, bytesToUint32(f))
+			}
+			if fmt.Sprint(acks) != fmt.Sprint(tc.acks) {
+				t.Errorf("acks %x, want %x", acks, tc.acks)
+			}
+		})
+	}
+}
+
+func TestFlashMD5(t *testing.T) {
+	sum := md5.Sum([]byte("nas-ui"))
+	hexed := []byte(hex.EncodeToString(sum[:]))
+
+	tests := []struct {
+		name  string
+		stub  bool
+		reply []byte
+		err   string
+	}{
+		{"stub raw", true, append(append([]byte{}, sum[:]...), 0x00, 0x00), ""},
+		{"rom hex", false, append(append([]byte{}, hexed...), 0x00, 0x00, 0x00, 0x00), ""},
+		{"stub short", true, append(append([]byte{}, sum[:8]...), 0x00, 0x00), "short reply"},
+		{"rom short", false, append(append([]byte{}, hexed[:8]...), 0x00, 0x00, 0x00, 0x00), "short reply"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &pipeDriver{}
+			d.respond(ESPOP_FLASHMD5, 0, tc.reply)
+			l := &Loader{drv: d, stub: tc.stub}
+
+			got, err := l.FlashMD5(0x10000, 0x2000)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got %v, want %q", err, tc.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, sum[:]) {
+				t.Errorf("got %x, want %x", got, sum)
+			}
+
+			cmd := sentFrames(t, d)[0]
+			if cmd[1] != ESPOP_FLASHMD5 || !bytes.Equal(cmd[8:], []byte{
+				0x00, 0x00, 0x01, 0x00, 0x00, 0x20, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			}) {
+				t.Errorf("command % x", cmd)
+			}
+		})
+	}
+}
+
+// baudPipe is a pipeDriver that can change its baud rate. The chip only
+// answers READ_REG on the new rate when alive is set.
+type baudPipe struct {
+	pipeDriver
+	baud   int
+	alive  bool
+	status []byte
+}
+
+func (d *baudPipe) BaudRate() int { return d.baud }
+
+func (d *baudPipe) SetBaudRate(baud int) error {
+	d.baud = baud
+	return nil
+}
+
+func (d *baudPipe) Write(p []byte) (int, error) {
+	if d.alive && len(p) > 2 && p[2] == ESPOP_READREG {
+		d.respond(ESPOP_READREG, 0x6921506f, d.status)
+	}
+	return d.pipeDriver.Write(p)
+}
+
+func TestChangeBaudRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		stub  bool
+		alive bool
+		reply []byte
+		args  []byte
+		baud  int
+		err   string
+	}{
+		{"stub", true, true, []byte{0x00, 0x00}, []byte{0x00, 0x10, 0x0E, 0x00, 0x00, 0xC2, 0x01, 0x00}, 921600, ""},
+		{"rom", false, true, []byte{0x00, 0x00, 0x00, 0x00}, []byte{0x00, 0x10, 0x0E, 0x00, 0x00, 0x00, 0x00, 0x00}, 921600, ""},
+		{"refused", true, true, []byte{0x01, 0xc0}, []byte{0x00, 0x10, 0x0E, 0x00, 0x00, 0xC2, 0x01, 0x00}, 115200, "bad data length"},
+		{"no answer", true, false, []byte{0x00, 0x00}, []byte{0x00, 0x10, 0x0E, 0x00, 0x00, 0xC2, 0x01, 0x00}, 921600, "no response at 921600 baud"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status := []byte{0x00, 0x00, 0x00, 0x00}
+			if tc.stub {
+				status = status[:2]
+			}
+			d := &baudPipe{baud: 115200, alive: tc.alive, status: status}
+			d.respond(ESPOP_CHANGEBAUDRATE, 0, tc.reply)
+			// What the switch garbled has to be flushed.
+			d.replies.Write([]byte{0x55, 0xC0, 0xAA})
+			l := &Loader{drv: d, stub: tc.stub}
+
+			err := l.ChangeBaudRate(921600)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got %v, want %q", err, tc.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if d.baud != tc.baud {
+				t.Errorf("driver at %d baud, want %d", d.baud, tc.baud)
+			}
+
+			cmd := sentFrames(t, &d.pipeDriver)[0]
+			if cmd[1] != ESPOP_CHANGEBAUDRATE || !bytes.Equal(cmd[8:], tc.args) {
+				t.Errorf("command % x", cmd)
+			}
+		})
+	}
+}
+
+func TestChangeBaudRateDriver(t *testing.T) {
+	l := &Loader{drv: &pipeDriver{}}
+	if err := l.ChangeBaudRate(921600); err == nil {
+		t.Fatal("changed the rate of a driver without one")
+	}
+}
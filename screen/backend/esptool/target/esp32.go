@@ -0,0 +1,32 @@
+package target
+
+type _esp32 struct {
+}
+
+func (e *_esp32) Name() string {
+	return "ESP32"
+}
+
+func (e *_esp32) ImageChipID() uint16 {
+	return 0
+}
+
+func (e *_esp32) EncryptedFlash() bool {
+	return false
+}
+
+func (e *_esp32) GetEraseSize(addr uint32, size uint32) uint32 {
+	return size
+}
+
+func (e *_esp32) BootloaderAddr() uint32 {
+	return 0x1000
+}
+
+func (e *_esp32) ReadMac(l Loader) ([]byte, error) {
+	return readMac(l, 0x3FF5A004)
+}
+
+func (e *_esp32) Stub(l Loader) (*Stub, error) {
+	return LoadStub(l, "stub_flasher_32.json")
+}
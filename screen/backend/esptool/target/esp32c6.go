@@ -0,0 +1,32 @@
+package target
+
+type _esp32c6 struct {
+}
+
+func (e *_esp32c6) Name() string {
+	return "ESP32-C6"
+}
+
+func (e *_esp32c6) ImageChipID() uint16 {
+	return 13
+}
+
+func (e *_esp32c6) EncryptedFlash() bool {
+	return true
+}
+
+func (e *_esp32c6) GetEraseSize(addr uint32, size uint32) uint32 {
+	return size
+}
+
+func (e *_esp32c6) BootloaderAddr() uint32 {
+	return 0x0
+}
+
+func (e *_esp32c6) ReadMac(l Loader) ([]byte, error) {
+	return readMac(l, 0x600B0844)
+}
+
+func (e *_esp32c6) Stub(l Loader) (*Stub, error) {
+	return LoadStub(l, "stub_flasher_32c6.json")
+}
@@ -0,0 +1,32 @@
+package target
+
+type _esp32h2 struct {
+}
+
+func (e *_esp32h2) Name() string {
+	return "ESP32-H2"
+}
+
+func (e *_esp32h2) ImageChipID() uint16 {
+	return 16
+}
+
+func (e *_esp32h2) EncryptedFlash() bool {
+	return true
+}
+
+func (e *_esp32h2) GetEraseSize(addr uint32, size uint32) uint32 {
+	return size
+}
+
+func (e *_esp32h2) BootloaderAddr() uint32 {
+	return 0x0
+}
+
+func (e *_esp32h2) ReadMac(l Loader) ([]byte, error) {
+	return readMac(l, 0x600B0844)
+}
+
+func (e *_esp32h2) Stub(l Loader) (*Stub, error) {
+	return LoadStub(l, "stub_flasher_32h2.json")
+}
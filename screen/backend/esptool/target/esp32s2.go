@@ -0,0 +1,32 @@
+package target
+
+type _esp32s2 struct {
+}
+
+func (e *_esp32s2) Name() string {
+	return "ESP32-S2"
+}
+
+func (e *_esp32s2) ImageChipID() uint16 {
+	return 2
+}
+
+func (e *_esp32s2) EncryptedFlash() bool {
+	return true
+}
+
+func (e *_esp32s2) GetEraseSize(addr uint32, size uint32) uint32 {
+	return size
+}
+
+func (e *_esp32s2) BootloaderAddr() uint32 {
+	return 0x1000
+}
+
+func (e *_esp32s2) ReadMac(l Loader) ([]byte, error) {
+	return readMac(l, 0x3F41A044)
+}
+
+func (e *_esp32s2) Stub(l Loader) (*Stub, error) {
+	return LoadStub(l, "stub_flasher_32s2.json")
+}
@@ -0,0 +1,32 @@
+package target
+
+type _esp32s3 struct {
+}
+
+func (e *_esp32s3) Name() string {
+	return "ESP32-S3"
+}
+
+func (e *_esp32s3) ImageChipID() uint16 {
+	return 9
+}
+
+func (e *_esp32s3) EncryptedFlash() bool {
+	return true
+}
+
+func (e *_esp32s3) GetEraseSize(addr uint32, size uint32) uint32 {
+	return size
+}
+
+func (e *_esp32s3) BootloaderAddr() uint32 {
+	return 0x0
+}
+
+func (e *_esp32s3) ReadMac(l Loader) ([]byte, error) {
+	return readMac(l, 0x60007044)
+}
+
+func (e *_esp32s3) Stub(l Loader) (*Stub, error) {
+	return LoadStub(l, "stub_flasher_32s3.json")
+}
@@ -0,0 +1,42 @@
+package target
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+const STUB_DIR = "embed/stub"
+
+// Stub is a flasher stub in the JSON format esptool ships, where text and
+// data are base64 encoded.
+type Stub struct {
+	Entry     uint32 `json:"entry"`
+	Text      []byte `json:"text"`
+	TextStart uint32 `json:"text_start"`
+	Data      []byte `json:"data"`
+	DataStart uint32 `json:"data_start"`
+}
+
+func LoadStub(l Loader, name string) (*Stub, error) {
+	raws, err := l.ReadFile(STUB_DIR + "/" + name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNoStub, name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	stub := &Stub{}
+	if err := json.Unmarshal(raws, stub); err != nil {
+		return nil, fmt.Errorf("target: stub %s: %w", name, err)
+	}
+
+	if len(stub.Text) == 0 || stub.Entry == 0 {
+		return nil, fmt.Errorf("target: stub %s: missing text or entry", name)
+	}
+
+	return stub, nil
+}
@@ -0,0 +1,97 @@
+package target
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+// fileLoader serves the stubs from the tree the way the embedded FS does.
+type fileLoader struct {
+	fsys fs.FS
+}
+
+func (l fileLoader) ReadReg(addr uint32) (uint32, error) {
+	return 0, errors.New("no chip")
+}
+
+func (l fileLoader) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(l.fsys, name)
+}
+
+func TestLoadStub(t *testing.T) {
+	l := fileLoader{os.DirFS("../..")}
+
+	stub, err := (&_esp32c3{}).Stub(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stub.Text) != 3748 || !bytes.HasPrefix(stub.Text, []byte{0x41, 0x11, 0x22, 0xc4, 0x26, 0xc2}) {
+		t.Errorf("text: %d bytes % x...", len(stub.Text), stub.Text[:6])
+	}
+
+	if len(stub.Data) != 160 {
+		t.Errorf("data: %d bytes", len(stub.Data))
+	}
+
+	if stub.TextStart != 0x40380000 || stub.DataStart != 0x3FC96BB0 || stub.Entry != 0x4038069C {
+		t.Errorf("text_start 0x%08x data_start 0x%08x entry 0x%08x", stub.TextStart, stub.DataStart, stub.Entry)
+	}
+}
+
+func TestLoadStubFiles(t *testing.T) {
+	stub := []byte(`{"entry": 1074521560, "text": "AQID", "text_start": 1074520064, "data": "", "data_start": 1073605544}`)
+
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		rom   ROM
+		err   error
+	}{
+		{"dropped in", fstest.MapFS{"embed/stub/stub_flasher_32s3.json": {Data: stub}}, &_esp32s3{}, nil},
+		{"esp32 missing", fstest.MapFS{}, &_esp32{}, ErrNoStub},
+		{"esp32s2 missing", fstest.MapFS{}, &_esp32s2{}, ErrNoStub},
+		{"esp32s3 missing", fstest.MapFS{}, &_esp32s3{}, ErrNoStub},
+		{"esp32c3 missing", fstest.MapFS{}, &_esp32c3{}, ErrNoStub},
+		{"esp32c6 missing", fstest.MapFS{}, &_esp32c6{}, ErrNoStub},
+		{"esp32h2 missing", fstest.MapFS{}, &_esp32h2{}, ErrNoStub},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.rom.Stub(fileLoader{tc.files})
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+
+			if tc.err == nil && (!bytes.Equal(got.Text, []byte{1, 2, 3}) || got.Entry != 0x400BE5D8) {
+				t.Errorf("text % x entry 0x%08x", got.Text, got.Entry)
+			}
+		})
+	}
+}
+
+func TestLoadStubErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", "stub"},
+		{"no text", `{"entry": 1}`},
+		{"no entry", `{"text": "AQID"}`},
+		{"bad base64", `{"entry": 1, "text": "!!"}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l := fileLoader{fstest.MapFS{"embed/stub/stub.json": {Data: []byte(tc.data)}}}
+			if _, err := LoadStub(l, "stub.json"); err == nil || errors.Is(err, ErrNoStub) {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
@@ -1,25 +1,89 @@
 package target
 
+import "errors"
+
+var ErrNoStub = errors.New("target: no flasher stub for chip")
+
 type Loader interface {
 	ReadReg(addr uint32) (uint32, error)
 	ReadFile(name string) ([]byte, error)
 }
 
+// ImageChipID is the chip_id an app or bootloader image built for the chip
+// carries in its header. EncryptedFlash reports whether the ROM loader takes
+// the extra encryption word in FLASH_BEGIN and FLASH_DEFL_BEGIN.
 type ROM interface {
+	Name() string
+	ImageChipID() uint16
+	EncryptedFlash() bool
+
 	GetEraseSize(addr uint32, size uint32) uint32
+	BootloaderAddr() uint32
 
 	ReadMac(l Loader) ([]byte, error)
 
-	StubText(l Loader) (uint32, []byte)
-	StubData(l Loader) (uint32, []byte)
-	StubEntry() uint32
+	Stub(l Loader) (*Stub, error)
+}
+
+// readMac reads the factory MAC from the two eFuse words starting at reg,
+// the layout every supported chip shares.
+func readMac(l Loader, reg uint32) ([]byte, error) {
+	mac0, err := l.ReadReg(reg)
+	if err != nil {
+		return nil, err
+	}
+
+	mac1, err := l.ReadReg(reg + 4)
+	if err != nil {
+		return nil, err
+	}
+
+	var macs [6]byte
+	macs[0] = byte(mac1 >> 8)
+	macs[1] = byte(mac1 >> 0)
+	macs[2] = byte(mac0 >> 24)
+	macs[3] = byte(mac0 >> 16)
+	macs[4] = byte(mac0 >> 8)
+	macs[5] = byte(mac0 >> 0)
+
+	return macs[:], nil
 }
 
 func MagicToRom(magic uint32) ROM {
 
 	switch magic {
+	case 0x00F01D83:
+		return &_esp32{}
+	case 0x000007C6:
+		return &_esp32s2{}
+	case 0x00000009:
+		return &_esp32s3{}
 	case 0x6921506f, 0x1b31506f, 0x4881606f, 0x4361606f:
 		return &_esp32c3{}
+	case 0x2CE0806F:
+		return &_esp32c6{}
+	case 0xD7B73E80:
+		return &_esp32h2{}
+	}
+
+	return nil
+}
+
+// ChipIDToRom maps the chip_id reported by GET_SECURITY_INFO, which newer
+// ROMs provide in place of a unique magic value.
+func ChipIDToRom(id uint32) ROM {
+
+	switch id {
+	case 2:
+		return &_esp32s2{}
+	case 5:
+		return &_esp32c3{}
+	case 9:
+		return &_esp32s3{}
+	case 13:
+		return &_esp32c6{}
+	case 16:
+		return &_esp32h2{}
 	}
 
 	return nil
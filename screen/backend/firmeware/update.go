@@ -1,48 +1,266 @@
 package firmeware
 
 import (
-	"io"
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
 	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/coorify/be/device"
 	"github.com/coorify/be/esptool"
+	"github.com/coorify/be/modbus"
 	"github.com/coorify/be/option"
 	"github.com/sirupsen/logrus"
 )
 
-func download(driver *device.Driver, efs fs.FS) error {
-	loader := esptool.NewLoader(driver, efs)
-	if err := loader.Open(); err != nil {
+const (
+	DEFAULT_FLASH_SIZE = 4 * 1024 * 1024
+	FLASH_RETRY        = 3
+	FLASH_GROUP        = 16 * esptool.ESP_FLASHSECTOR
+)
+
+// region is an image and where it goes. The bootloader address depends on
+// the chip and is filled in from the loader once it is connected.
+type region struct {
+	name string
+	addr uint32
+	boot bool
+}
+
+var regions = []region{
+	{"embed/bootloader.bin", 0, true},
+	{"embed/nas-ui.bin", 0x00010000, false},
+	{"embed/partition-table.bin", 0x00008000, false},
+}
+
+// checkChip refuses a bootloader image built for another chip than the one
+// the loader is connected to.
+func checkChip(loader *esptool.Loader, raws []byte) error {
+	if len(raws) < 16 || raws[0] != esptool.ESP_IMAGEMAGIC {
+		return fmt.Errorf("firmeware: bootloader is not an image")
+	}
+
+	rom := loader.ROM()
+	id := uint16(raws[12]) | uint16(raws[13])<<8
+	if id != rom.ImageChipID() {
+		return fmt.Errorf("firmeware: images are built for chip id %d, not %s", id, rom.Name())
+	}
+
+	return nil
+}
+
+// changed compares raws with the flash at addr one FLASH_GROUP at a time
+// and returns the offsets of the groups that differ, merged into runs.
+func changed(loader *esptool.Loader, addr uint32, raws []byte) ([][2]int, error) {
+	var runs [][2]int
+
+	for off := 0; off < len(raws); off += FLASH_GROUP {
+		end := off + FLASH_GROUP
+		if end > len(raws) {
+			end = len(raws)
+		}
+
+		got, err := loader.FlashMD5(addr+uint32(off), uint32(end-off))
+		if err != nil {
+			return nil, err
+		}
+
+		want := md5.Sum(raws[off:end])
+		if bytes.Equal(got, want[:]) {
+			continue
+		}
+
+		if n := len(runs); n > 0 && runs[n-1][1] == off {
+			runs[n-1][1] = end
+		} else {
+			runs = append(runs, [2]int{off, end})
+		}
+	}
+
+	return runs, nil
+}
+
+// flash rewrites only the sector groups of an image that differ from the
+// chip, letting FLASH_DEFL_BEGIN erase just what it writes, then checks the
+// whole image. A mismatch sends it round again until FLASH_RETRY attempts
+// are used up.
+func flash(loader *esptool.Loader, r region, raws []byte) error {
+	want := md5.Sum(raws)
+
+	var got []byte
+	for attempt := 1; attempt <= FLASH_RETRY; attempt++ {
+		runs, err := changed(loader, r.addr, raws)
+		if err != nil {
+			return err
+		}
+
+		if len(runs) == 0 && attempt == 1 {
+			logrus.Infof("firmeware: %s unchanged at 0x%08x", r.name, r.addr)
+			return nil
+		}
+
+		for _, run := range runs {
+			logrus.Infof("firmeware: %s write 0x%08x-0x%08x", r.name, r.addr+uint32(run[0]), r.addr+uint32(run[1]))
+			if err := loader.WriteFlash(r.addr+uint32(run[0]), raws[run[0]:run[1]]); err != nil {
+				return err
+			}
+		}
+
+		got, err = loader.FlashMD5(r.addr, uint32(len(raws)))
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(got, want[:]) {
+			logrus.Infof("firmeware: %s verified at 0x%08x", r.name, r.addr)
+			return nil
+		}
+
+		logrus.Warnf("firmeware: %s md5 mismatch at 0x%08x (attempt %d of %d)", r.name, r.addr, attempt, FLASH_RETRY)
+	}
+
+	return fmt.Errorf("firmeware: %s at 0x%08x: md5 %x, expected %x", r.name, r.addr, got, want)
+}
+
+// backup saves the whole flash of the screen into dir before it is rewritten.
+// Reading flash takes the stub, on the ROM loader it returns ErrNeedStub.
+func backup(loader *esptool.Loader, dir string) error {
+	if !loader.Stub() {
+		return esptool.ErrNeedStub
+	}
+
+	size, err := loader.FlashSize()
+	if err != nil {
+		logrus.Warnf("firmeware: flash size: %v, assume %d bytes", err, DEFAULT_FLASH_SIZE)
+		size = DEFAULT_FLASH_SIZE
+	}
+
+	mac, err := loader.ReadMac()
+	if err != nil {
 		return err
 	}
-	defer loader.Close()
 
-	if err := loader.EraseFlash(); err != nil {
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	var raws []byte
-	f, _ := efs.Open("embed/bootloader.bin")
-	raws, _ = io.ReadAll(f)
-	if err := loader.WriteFlash(0x00000000, raws); err != nil {
+	f, err := os.CreateTemp(dir, ".backup-*")
+	if err != nil {
 		return err
 	}
-	f.Close()
+	defer os.Remove(f.Name())
 
-	f, _ = efs.Open("embed/nas-ui.bin")
-	raws, _ = io.ReadAll(f)
-	if err := loader.WriteFlash(0x00010000, raws); err != nil {
+	logrus.Infof("firmeware: backup %d bytes of flash...", size)
+	if err := loader.ReadFlash(0, size, f); err != nil {
+		f.Close()
 		return err
 	}
-	f.Close()
 
-	f, _ = efs.Open("embed/partition-table.bin")
-	raws, _ = io.ReadAll(f)
-	if err := loader.WriteFlash(0x00008000, raws); err != nil {
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("screen-%s-%s.bin", strings.ReplaceAll(mac, ":", ""), time.Now().Format("20060102-150405"))
+	if err := os.Rename(f.Name(), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+
+	logrus.Infof("firmeware: backup saved to %s", filepath.Join(dir, name))
+	return nil
+}
+
+// flashDriver is the port as connect uses it: the loader's transport, its
+// baud rate and the lines that reset the screen.
+type flashDriver interface {
+	esptool.Driver
+	device.Resetter
+	BaudRate() int
+	SetBaudRate(int) error
+}
+
+// reboot resets the screen, into download mode or not. Tests swap it for
+// one that does not wait seconds for the chip.
+var reboot = device.Reboot
+
+// connect opens the loader and moves it to o.Baud. When the link does not
+// survive the switch the screen is put back into download mode and flashed
+// at the default rate instead.
+func connect(driver flashDriver, o *option.UpdateOption) (*esptool.Loader, error) {
+	loader := esptool.NewLoader(driver, o.EmbedFS)
+	if err := loader.Open(); err != nil {
+		return nil, err
+	}
+
+	if o.Baud == 0 || o.Baud == device.DEFAULT_BAUDRATE {
+		return loader, nil
+	}
+
+	err := loader.ChangeBaudRate(o.Baud)
+	if err == nil {
+		return loader, nil
+	}
+
+	logrus.Warnf("firmeware: %v, fall back to %d baud", err, device.DEFAULT_BAUDRATE)
+	loader.Close()
+	driver.SetBaudRate(device.DEFAULT_BAUDRATE)
+	reboot(driver, true)
+
+	loader = esptool.NewLoader(driver, o.EmbedFS)
+	if err := loader.Open(); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+func download(driver *device.Driver, o *option.UpdateOption) error {
+	// The driver is shared with modbus, which expects the default rate.
+	defer driver.SetBaudRate(device.DEFAULT_BAUDRATE)
+
+	efs := o.EmbedFS
+	loader, err := connect(driver, o)
+	if err != nil {
 		return err
 	}
-	f.Close()
+	defer loader.Close()
+
+	images := make([][]byte, len(regions))
+	for i, r := range regions {
+		raws, err := fs.ReadFile(efs, r.name)
+		if err != nil {
+			return err
+		}
+
+		if r.boot {
+			if err := checkChip(loader, raws); err != nil {
+				return err
+			}
+		}
+		images[i] = raws
+	}
+
+	if o.Backup != "" {
+		err := backup(loader, o.Backup)
+		if errors.Is(err, esptool.ErrNeedStub) {
+			logrus.Warnf("firmeware: backup skipped, %s has no flasher stub to read flash with", loader.ROM().Name())
+		} else if err != nil {
+			return fmt.Errorf("firmeware: backup: %w", err)
+		}
+	}
+
+	for i, r := range regions {
+		if r.boot {
+			r.addr = loader.ROM().BootloaderAddr()
+		}
+
+		if err := flash(loader, r, images[i]); err != nil {
+			return err
+		}
+	}
 
 	if err := loader.WriteFlashFinish(); err != nil {
 		return err
@@ -52,11 +270,14 @@ func download(driver *device.Driver, efs fs.FS) error {
 	return nil
 }
 
-func Update(driver *device.Driver, o *option.UpdateOption) error {
+func Update(driver *device.Driver, mdb *modbus.Modbus, o *option.UpdateOption) error {
 	device.Reboot(driver, false)
 
 	ever := o.Version
-	hver := Version(driver)
+	hver, err := Version(mdb, o.Unit)
+	if err != nil {
+		logrus.Warnf("firmeware: read hardware version: %v", err)
+	}
 
 	logrus.Infof("firmeware: hardware(%v) embedded(%v)", hver, ever)
 	if hver == ever {
@@ -66,7 +287,7 @@ func Update(driver *device.Driver, o *option.UpdateOption) error {
 	logrus.Warnf("firmeware: update to %v", ever)
 	device.Reboot(driver, true)
 
-	if err := download(driver, o.EmbedFS); err != nil {
+	if err := download(driver, o); err != nil {
 		return err
 	}
 
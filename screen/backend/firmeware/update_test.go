@@ -0,0 +1,299 @@
+package firmeware
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/coorify/be/device"
+	"github.com/coorify/be/esptool"
+	"github.com/coorify/be/option"
+)
+
+// fakeChip plays an ESP32-C3 ROM loader with a flash behind it, and the
+// port it sits on. It answers every command as soon as it is written, so
+// reads never wait. Nothing gets through while the port and the chip are
+// on different rates, or above maxBaud.
+type fakeChip struct {
+	flash    []byte
+	corrupt  int
+	writes   [][2]uint32
+	zoffset  uint32
+	zimage   []byte
+	out      bytes.Buffer
+	baud     int
+	chipBaud int
+	maxBaud  int
+	changes  [][2]uint32
+	reboots  int
+}
+
+func newFakeChip() *fakeChip {
+	return &fakeChip{
+		flash:    bytes.Repeat([]byte{0xFF}, 1024*1024),
+		baud:     device.DEFAULT_BAUDRATE,
+		chipBaud: device.DEFAULT_BAUDRATE,
+		maxBaud:  2000000,
+	}
+}
+
+func (c *fakeChip) Open() error           { return nil }
+func (c *fakeChip) Close() error          { return nil }
+func (c *fakeChip) SetDTR(dtr bool) error { return nil }
+func (c *fakeChip) SetRTS(rts bool) error { return nil }
+func (c *fakeChip) BaudRate() int         { return c.baud }
+
+func (c *fakeChip) SetBaudRate(baud int) error {
+	c.baud = baud
+	return nil
+}
+
+func (c *fakeChip) link() bool {
+	return c.baud == c.chipBaud && c.baud <= c.maxBaud
+}
+
+func (c *fakeChip) Read(p []byte) (int, error) {
+	return c.out.Read(p)
+}
+
+// Write takes one SLIP framed command, the way the loader sends them.
+func (c *fakeChip) Write(p []byte) (int, error) {
+	if !c.link() {
+		return len(p), nil
+	}
+
+	pkt, err := esptool.SlipRead(bytes.NewReader(p), time.Second)
+	if err != nil {
+		return 0, err
+	}
+
+	c.handle(pkt[1], pkt[8:])
+	return len(p), nil
+}
+
+func (c *fakeChip) handle(op byte, data []byte) {
+	le := binary.LittleEndian
+
+	switch op {
+	case esptool.ESPOP_SECURITYINFO:
+		info := make([]byte, 20)
+		le.PutUint32(info[12:], 5)
+		c.reply(op, info)
+	case esptool.ESPOP_CHANGEBAUDRATE:
+		// The answer still goes out at the old rate.
+		c.changes = append(c.changes, [2]uint32{le.Uint32(data), le.Uint32(data[4:])})
+		c.reply(op, nil)
+		c.chipBaud = int(le.Uint32(data))
+	case esptool.ESPOP_FLASHDEFLBEGIN:
+		c.zoffset = le.Uint32(data[12:])
+		c.zimage = nil
+		c.reply(op, nil)
+	case esptool.ESPOP_FLASHDEFLDATA:
+		c.zimage = append(c.zimage, data[16:16+le.Uint32(data)]...)
+		c.inflate()
+		c.reply(op, nil)
+	case esptool.ESPOP_FLASHMD5:
+		addr, size := le.Uint32(data), le.Uint32(data[4:])
+		sum := md5.Sum(c.flash[addr : addr+size])
+		c.reply(op, []byte(hex.EncodeToString(sum[:])))
+	default:
+		c.reply(op, nil)
+	}
+}
+
+// inflate writes the image once all of it has arrived, spoiling its first
+// byte while corrupt says so.
+func (c *fakeChip) inflate() {
+	r, err := zlib.NewReader(bytes.NewReader(c.zimage))
+	if err != nil {
+		return
+	}
+
+	raws, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	copy(c.flash[c.zoffset:], raws)
+	if c.corrupt > 0 {
+		c.corrupt--
+		c.flash[c.zoffset] ^= 0xFF
+	}
+	c.writes = append(c.writes, [2]uint32{c.zoffset, uint32(len(raws))})
+}
+
+// reply answers op with data followed by the four status bytes of the ROM.
+func (c *fakeChip) reply(op byte, data []byte) {
+	pkt := []byte{0x01, op, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(pkt[2:], uint16(len(data)+4))
+	pkt = append(pkt, data...)
+	pkt = append(pkt, 0, 0, 0, 0)
+	esptool.SlipWrite(&c.out, pkt)
+}
+
+func openFakeChip(t *testing.T, c *fakeChip) *esptool.Loader {
+	t.Helper()
+
+	// Without a stub in the FS the loader stays on the ROM.
+	loader := esptool.NewLoader(c, fstest.MapFS{})
+	if err := loader.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return loader
+}
+
+// testImage is four and a half FLASH_GROUPs of bytes that do not repeat
+// within a group.
+func testImage() []byte {
+	image := make([]byte, 4*FLASH_GROUP+FLASH_GROUP/2)
+	for i := range image {
+		image[i] = byte(i * 7 / 5)
+	}
+	return image
+}
+
+func TestChanged(t *testing.T) {
+	const addr = 0x10000
+	image := testImage()
+	end := len(image)
+
+	tests := []struct {
+		name  string
+		diffs []int
+		want  [][2]int
+	}{
+		{"same", nil, nil},
+		{"one group", []int{FLASH_GROUP + 3}, [][2]int{{FLASH_GROUP, 2 * FLASH_GROUP}}},
+		{"two bytes one group", []int{5, FLASH_GROUP - 1}, [][2]int{{0, FLASH_GROUP}}},
+		{"adjacent merged", []int{FLASH_GROUP, 2*FLASH_GROUP + 9}, [][2]int{{FLASH_GROUP, 3 * FLASH_GROUP}}},
+		{"apart", []int{0, 2 * FLASH_GROUP}, [][2]int{{0, FLASH_GROUP}, {2 * FLASH_GROUP, 3 * FLASH_GROUP}}},
+		{"short last group", []int{end - 1}, [][2]int{{4 * FLASH_GROUP, end}}},
+		{"all", []int{0, FLASH_GROUP, 2 * FLASH_GROUP, 3 * FLASH_GROUP, end - 1}, [][2]int{{0, end}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeChip()
+			copy(c.flash[addr:], image)
+			loader := openFakeChip(t, c)
+
+			raws := append([]byte{}, image...)
+			for _, off := range tc.diffs {
+				raws[off] ^= 0xFF
+			}
+
+			runs, err := changed(loader, addr, raws)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(runs) != fmt.Sprint(tc.want) {
+				t.Errorf("runs %x, want %x", runs, tc.want)
+			}
+		})
+	}
+}
+
+func TestFlash(t *testing.T) {
+	const addr = 0x10000
+	image := testImage()
+	whole := [2]uint32{addr, uint32(len(image))}
+	first := [2]uint32{addr, FLASH_GROUP}
+
+	tests := []struct {
+		name    string
+		present bool
+		corrupt int
+		writes  [][2]uint32
+		err     string
+	}{
+		{"unchanged", true, 0, nil, ""},
+		{"blank", false, 0, [][2]uint32{whole}, ""},
+		{"retry", false, 1, [][2]uint32{whole, first}, ""},
+		{"retry twice", false, FLASH_RETRY - 1, [][2]uint32{whole, first, first}, ""},
+		{"retries used up", false, FLASH_RETRY, [][2]uint32{whole, first, first}, "expected"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeChip()
+			if tc.present {
+				copy(c.flash[addr:], image)
+			}
+			c.corrupt = tc.corrupt
+			loader := openFakeChip(t, c)
+
+			err := flash(loader, region{name: "embed/nas-ui.bin", addr: addr}, image)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got %v, want %q", err, tc.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(c.flash[addr:addr+len(image)], image) {
+				t.Error("flash does not hold the image")
+			}
+
+			if fmt.Sprint(c.writes) != fmt.Sprint(tc.writes) {
+				t.Errorf("writes %x, want %x", c.writes, tc.writes)
+			}
+		})
+	}
+}
+
+func TestConnect(t *testing.T) {
+	defer func(r func(device.Resetter, bool)) { reboot = r }(reboot)
+	reboot = func(drv device.Resetter, download bool) {
+		c := drv.(*fakeChip)
+		c.reboots++
+		c.chipBaud = device.DEFAULT_BAUDRATE
+		c.out.Reset()
+	}
+
+	tests := []struct {
+		name    string
+		baud    int
+		maxBaud int
+		changes [][2]uint32
+		reboots int
+		want    int
+	}{
+		{"default rate", 0, 2000000, nil, 0, device.DEFAULT_BAUDRATE},
+		{"same rate", device.DEFAULT_BAUDRATE, 2000000, nil, 0, device.DEFAULT_BAUDRATE},
+		// The ROM wants zero in place of the current rate.
+		{"fast", 921600, 2000000, [][2]uint32{{921600, 0}}, 0, 921600},
+		{"fall back", 921600, device.DEFAULT_BAUDRATE, [][2]uint32{{921600, 0}}, 1, device.DEFAULT_BAUDRATE},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeChip()
+			c.maxBaud = tc.maxBaud
+
+			loader, err := connect(c, &option.UpdateOption{Baud: tc.baud, EmbedFS: fstest.MapFS{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.baud != tc.want || c.chipBaud != tc.want {
+				t.Errorf("port at %d baud, chip at %d, want %d", c.baud, c.chipBaud, tc.want)
+			}
+
+			if fmt.Sprint(c.changes) != fmt.Sprint(tc.changes) || c.reboots != tc.reboots {
+				t.Errorf("changes %v reboots %d, want %v and %d", c.changes, c.reboots, tc.changes, tc.reboots)
+			}
+
+			if _, err := loader.FlashMD5(0, 16); err != nil {
+				t.Errorf("link lost: %v", err)
+			}
+		})
+	}
+}
@@ -1,30 +1,22 @@
 package firmeware
 
 import (
-	"github.com/coorify/be/device"
+	"context"
+
 	"github.com/coorify/be/modbus"
 )
 
-func Version(driver *device.Driver) uint16 {
-	mdb := modbus.New(driver, modbus.NewRTUParser())
-
-	mdb.Open()
-	defer mdb.Close()
-
-	req := modbus.NewRequest(modbus.OPCODE_READ_HOLDING_REGISTERS)
-	req.Address = 0
-	req.SetLength(1)
-
-	rep := mdb.Exec(1, req)
-	if rep == nil {
-		return 0
+func Version(mdb *modbus.Modbus, unit uint8) (uint16, error) {
+	if err := mdb.Open(); err != nil {
+		return 0, err
 	}
+	defer mdb.Close()
 
-	pyd := rep.Payload()
-	if pyd == nil {
-		return 0
+	ctx := modbus.WithPriority(context.Background(), modbus.PriorityHigh)
+	regs, err := mdb.ReadHoldingRegisters(ctx, unit, 0, 1)
+	if err != nil {
+		return 0, err
 	}
 
-	pydU16 := pyd.(modbus.PayloadU16)
-	return pydU16.Get(0)
+	return regs[0], nil
 }
@@ -2,12 +2,14 @@ package main
 
 import (
 	"embed"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/coorify/be/device"
 	"github.com/coorify/be/firmeware"
+	"github.com/coorify/be/modbus"
 	"github.com/coorify/be/monitor"
 	"github.com/coorify/be/openwrt"
 	"github.com/coorify/be/option"
@@ -33,6 +35,27 @@ func load(opt *option.Option) error {
 	return loader.Load(opt, files)
 }
 
+func gateway(mdb *modbus.Modbus, o *option.GatewayOption) (*modbus.Gateway, error) {
+	tables := map[string]modbus.Table{
+		"coils":    modbus.TABLE_COILS,
+		"discrete": modbus.TABLE_DISCRETE_INPUTS,
+		"holding":  modbus.TABLE_HOLDING_REGISTERS,
+		"input":    modbus.TABLE_INPUT_REGISTERS,
+	}
+
+	gw := modbus.NewGateway(mdb, o.Listen, o.Unit)
+	for _, r := range o.Ranges {
+		table, ok := tables[r.Table]
+		if !ok {
+			return nil, fmt.Errorf("gateway: unknown table %q", r.Table)
+		}
+
+		gw.Allow(modbus.Range{Table: table, Start: r.Start, End: r.End, ReadOnly: r.ReadOnly})
+	}
+
+	return gw, nil
+}
+
 func main() {
 	o := &option.Option{}
 	if err := load(o); err != nil {
@@ -46,26 +69,62 @@ func main() {
 
 	uo := &option.UpdateOption{
 		Version: uint16(0x0005),
+		Unit:    monitor.DefaultScreen.Unit,
+		Backup:  o.Firmware.Backup,
+		Baud:    o.Firmware.Baud,
 		EmbedFS: embedFS,
 	}
+	if len(o.Monitor.Screens) > 0 {
+		uo.Unit = o.Monitor.Screens[0].Unit
+	}
 
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, syscall.SIGINT)
 
 	name := device.WaitPort()
 	drv := device.NewDriver(name)
+	var mdrv modbus.Driver = drv
+	if o.Capture.Enable {
+		f, err := os.OpenFile(o.Capture.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		mdrv = modbus.NewCaptureDriver(drv, f)
+	}
+
+	mdb := modbus.New(mdrv, modbus.NewRTUParser())
 
-	if err := firmeware.Update(drv, uo); err != nil {
+	if err := firmeware.Update(drv, mdb, uo); err != nil {
 		panic(err)
 	}
 
-	mtr := monitor.NewMonitor(drv, wrt)
+	mtr := monitor.NewMonitor(mdb, wrt, &o.Monitor)
 	if err := mtr.Start(); err != nil {
 		panic(err)
 	}
 
+	var gw *modbus.Gateway
+	if o.Gateway.Enable {
+		var err error
+		if gw, err = gateway(mdb, &o.Gateway); err != nil {
+			panic(err)
+		}
+
+		if err := gw.Start(); err != nil {
+			panic(err)
+		}
+	}
+
 	<-sigint
 
+	if gw != nil {
+		if err := gw.Stop(); err != nil {
+			panic(err)
+		}
+	}
+
 	if err := mtr.Stop(); err != nil {
 		panic(err)
 	}
@@ -20,12 +20,13 @@ type Buffer interface {
 	WriteU8(v uint8) bool
 	WriteU16(v uint16, msb bool) bool
 	Write(v []uint8) int
-	WriteTo(w io.Writer) int
+	WriteTo(w io.Writer) (int64, error)
 
 	ReadU8(v *uint8) bool
 	ReadU16(v *uint16, msb bool) bool
 	Read(v []uint8) int
-	ReadFrom(r io.Reader) int
+	Skip(n int) int
+	ReadFrom(r io.Reader) (int64, error)
 }
 
 type _buffer struct {
@@ -66,18 +67,35 @@ func (b *_buffer) Length() int {
 }
 
 func (b *_buffer) WriteU8(v uint8) bool {
-	return b.Write([]uint8{v}) == 1
+	if b.IsFull() {
+		return false
+	}
+
+	b.raws[b.writpos] = v
+	b.writpos += 1
+	b.writpos %= b.capacity
+	b.flag &= ^BUFFER_EMPTY
+	if b.writpos == b.readpos {
+		b.flag |= BUFFER_FULL
+	}
+
+	return true
 }
 
 func (b *_buffer) WriteU16(v uint16, msb bool) bool {
-	var v8 []uint8
+	if b.Free() < 2 {
+		return false
+	}
+
 	if msb {
-		v8 = []uint8{(uint8(v >> 8)), uint8(v)}
+		b.WriteU8(uint8(v >> 8))
+		b.WriteU8(uint8(v))
 	} else {
-		v8 = []uint8{(uint8(v)), uint8(v >> 8)}
+		b.WriteU8(uint8(v))
+		b.WriteU8(uint8(v >> 8))
 	}
 
-	return b.Write(v8) == 2
+	return true
 }
 
 func (b *_buffer) Write(v []uint8) int {
@@ -118,10 +136,10 @@ func (b *_buffer) Write(v []uint8) int {
 	return writed
 }
 
-func (b *_buffer) WriteTo(w io.Writer) int {
-	writed := 0
+func (b *_buffer) WriteTo(w io.Writer) (int64, error) {
+	writed := int64(0)
 	if b.IsEmpty() {
-		return writed
+		return writed, nil
 	}
 
 	read_pos := b.raws[b.readpos:]
@@ -132,9 +150,9 @@ func (b *_buffer) WriteTo(w io.Writer) int {
 		read_len = b.capacity - b.readpos
 	}
 
-	write_len, _ := w.Write(read_pos[0:read_len])
+	write_len, err := w.Write(read_pos[0:read_len])
 	if write_len == 0 {
-		return writed
+		return writed, err
 	}
 
 	b.readpos += write_len
@@ -144,12 +162,17 @@ func (b *_buffer) WriteTo(w io.Writer) int {
 		b.flag |= BUFFER_EMPTY
 	}
 
-	writed += write_len
+	writed += int64(write_len)
+	if err != nil {
+		return writed, err
+	}
+
 	if read_len == write_len {
-		writed += b.WriteTo(w)
+		n, err := b.WriteTo(w)
+		return writed + n, err
 	}
 
-	return writed
+	return writed, nil
 }
 
 func (b *_buffer) Read(v []uint8) int {
@@ -189,11 +212,30 @@ func (b *_buffer) Read(v []uint8) int {
 	return readed
 }
 
-func (b *_buffer) ReadFrom(r io.Reader) int {
-	writed := 0
+func (b *_buffer) Skip(n int) int {
+	if l := b.Length(); n > l {
+		n = l
+	}
+
+	if n <= 0 {
+		return 0
+	}
+
+	b.readpos += n
+	b.readpos %= b.capacity
+	b.flag &= ^BUFFER_FULL
+	if b.readpos == b.writpos {
+		b.flag |= BUFFER_EMPTY
+	}
+
+	return n
+}
+
+func (b *_buffer) ReadFrom(r io.Reader) (int64, error) {
+	writed := int64(0)
 
 	if b.IsFull() {
-		return writed
+		return writed, nil
 	}
 
 	write_pos := b.raws[b.writpos:]
@@ -204,9 +246,9 @@ func (b *_buffer) ReadFrom(r io.Reader) int {
 		write_len = b.capacity - b.writpos
 	}
 
-	read_len, _ := r.Read(write_pos[0:write_len])
+	read_len, err := r.Read(write_pos[0:write_len])
 	if read_len == 0 {
-		return writed
+		return writed, err
 	}
 
 	b.writpos += read_len
@@ -216,36 +258,116 @@ func (b *_buffer) ReadFrom(r io.Reader) int {
 		b.flag |= BUFFER_FULL
 	}
 
-	writed += read_len
+	writed += int64(read_len)
+	if err != nil {
+		return writed, err
+	}
+
 	if read_len == write_len {
-		writed += b.ReadFrom(r)
+		n, err := b.ReadFrom(r)
+		return writed + n, err
 	}
 
-	return writed
+	return writed, nil
 }
 
 func (b *_buffer) ReadU8(v *uint8) bool {
-	buf := []uint8{0}
-	n := b.Read(buf)
-	*v = buf[0]
-	return n == 1
+	if b.IsEmpty() {
+		*v = 0
+		return false
+	}
+
+	*v = b.raws[b.readpos]
+	b.readpos += 1
+	b.readpos %= b.capacity
+	b.flag &= ^BUFFER_FULL
+	if b.readpos == b.writpos {
+		b.flag |= BUFFER_EMPTY
+	}
+
+	return true
 }
 
 func (b *_buffer) ReadU16(v *uint16, msb bool) bool {
-	v8 := []uint8{0, 0}
-	if b.Read(v8) != 2 {
+	if b.Length() < 2 {
 		return false
 	}
 
+	hi := uint8(0)
+	lo := uint8(0)
 	if msb {
-		*v = (uint16(v8[0]) << 8) | uint16(v8[1])
+		b.ReadU8(&hi)
+		b.ReadU8(&lo)
 	} else {
-		*v = (uint16(v8[1]) << 8) | uint16(v8[0])
+		b.ReadU8(&lo)
+		b.ReadU8(&hi)
 	}
 
+	*v = (uint16(hi) << 8) | uint16(lo)
 	return true
 }
 
+// peek returns the byte i positions past the read position without
+// consuming it.
+func (b *_buffer) peek(i int) uint8 {
+	return b.raws[(b.readpos+i)%b.capacity]
+}
+
+func (b *_buffer) poke(i int, v uint8) {
+	b.raws[(b.readpos+i)%b.capacity] = v
+}
+
+func (b *_buffer) reset() {
+	b.readpos = 0
+	b.writpos = 0
+	b.flag = BUFFER_EMPTY
+}
+
+// The codecs work on *_buffer directly rather than going through the
+// interface on every byte. Any other Buffer implementation takes the slow
+// path below: the codec runs on a scratch copy and the outcome is applied
+// to b afterwards.
+
+// scratch copies what b holds into a buffer with room for as much again as
+// b can still take, plus one byte so it never has zero capacity.
+func scratch(b Buffer) *_buffer {
+	raws := make([]uint8, b.Length())
+	b.Clone().Read(raws)
+
+	capacity := len(raws) + b.Free() + 1
+	buf := &_buffer{capacity: capacity, flag: BUFFER_EMPTY, raws: make([]uint8, capacity)}
+	buf.Write(raws)
+	return buf
+}
+
+// encodeSlow appends to b what encode writes to an empty scratch buffer.
+func encodeSlow(b Buffer, encode func(buf *_buffer) error) error {
+	capacity := b.Free() + 1
+	buf := &_buffer{capacity: capacity, flag: BUFFER_EMPTY, raws: make([]uint8, capacity)}
+	if err := encode(buf); err != nil {
+		return err
+	}
+
+	raws := make([]uint8, buf.Length())
+	buf.Read(raws)
+	if b.Write(raws) != len(raws) {
+		return ErrEncode
+	}
+
+	return nil
+}
+
+// decodeSlow runs decode on a copy of b and then consumes from b as many
+// bytes as decode did from the copy.
+func decodeSlow(b Buffer, decode func(buf *_buffer) error) error {
+	buf := scratch(b)
+	before := buf.Length()
+
+	err := decode(buf)
+	b.Skip(before - buf.Length())
+	return err
+}
+
 func (b *_buffer) Clone() Buffer {
 	return &_buffer{
 		capacity: b.capacity,
@@ -0,0 +1,362 @@
+package modbus
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CAPTURE_TX = "tx"
+	CAPTURE_RX = "rx"
+)
+
+// CaptureRecord is one line of a JSONL capture file: the bytes a single
+// Read or Write moved through the driver.
+type CaptureRecord struct {
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"`
+	Data string    `json:"data"`
+}
+
+type _captureDriver struct {
+	driver Driver
+	mu     sync.Mutex
+	enc    *json.Encoder
+}
+
+// NewCaptureDriver taps driver and appends every frame it sends or receives
+// to w as a CaptureRecord.
+func NewCaptureDriver(driver Driver, w io.Writer) Driver {
+	return &_captureDriver{
+		driver: driver,
+		enc:    json.NewEncoder(w),
+	}
+}
+
+func (d *_captureDriver) record(dir string, p []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.enc.Encode(&CaptureRecord{
+		Time: time.Now(),
+		Dir:  dir,
+		Data: hex.EncodeToString(p),
+	})
+}
+
+func (d *_captureDriver) Open() error {
+	return d.driver.Open()
+}
+
+func (d *_captureDriver) Close() error {
+	return d.driver.Close()
+}
+
+func (d *_captureDriver) BaudRate() int {
+	if drv, ok := d.driver.(baudDriver); ok {
+		return drv.BaudRate()
+	}
+	return 0
+}
+
+func (d *_captureDriver) SilenceFloor() time.Duration {
+	if drv, ok := d.driver.(floorDriver); ok {
+		return drv.SilenceFloor()
+	}
+	return 0
+}
+
+func (d *_captureDriver) SetReadTimeout(t time.Duration) error {
+	if drv, ok := d.driver.(timeoutDriver); ok {
+		return drv.SetReadTimeout(t)
+	}
+	return nil
+}
+
+func (d *_captureDriver) Read(p []byte) (int, error) {
+	n, err := d.driver.Read(p)
+	if n > 0 {
+		d.record(CAPTURE_RX, p[:n])
+	}
+	return n, err
+}
+
+func (d *_captureDriver) Write(p []byte) (int, error) {
+	n, err := d.driver.Write(p)
+	if n > 0 {
+		d.record(CAPTURE_TX, p[:n])
+	}
+	return n, err
+}
+
+// Frame is a decoded capture frame. Err is ErrChecksum for bytes dropped
+// while resyncing and ErrShortFrame for a frame cut off by the other side.
+type Frame struct {
+	Time    time.Time
+	Dir     string
+	Unit    uint8
+	Request *Request
+	Reply   *Reply
+	Raw     []byte
+	Err     error
+}
+
+func (f *Frame) String() string {
+	s := &strings.Builder{}
+	fmt.Fprintf(s, "%s %s ", f.Time.Format("15:04:05.000000"), f.Dir)
+
+	switch {
+	case f.Err != nil:
+		fmt.Fprintf(s, "%v [% x]", f.Err, f.Raw)
+	case f.Request != nil:
+		fmt.Fprintf(s, "unit=%d %s", f.Unit, describeRequest(f.Request))
+	case f.Reply != nil:
+		fmt.Fprintf(s, "unit=%d %s", f.Unit, describeReply(f.Reply))
+	}
+
+	return s.String()
+}
+
+func describeBits(p Payload, n int) string {
+	bits, ok := p.(*_payloadBit)
+	if !ok {
+		return ""
+	}
+
+	if n < 0 || n > len(bits.raws)*8 {
+		n = len(bits.raws) * 8
+	}
+
+	values := make([]string, n)
+	for i := range values {
+		values[i] = "0"
+		if bits.Get(i) {
+			values[i] = "1"
+		}
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
+
+func describeRegisters(p Payload) string {
+	regs, ok := p.(*_payloadU16)
+	if !ok {
+		return ""
+	}
+
+	values := make([]string, len(regs.raws)/2)
+	for i := range values {
+		values[i] = fmt.Sprintf("%d", regs.Get(i))
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
+
+func describeRequest(r *Request) string {
+	fc := fmt.Sprintf("fc=0x%02x", r.opcode)
+
+	switch r.opcode {
+	case OPCODE_READ_COILS, OPCODE_DISCRETE_INPUTS, OPCODE_READ_HOLDING_REGISTERS, OPCODE_READ_INPUT_REGISTERS:
+		return fmt.Sprintf("%s addr=%d qty=%d", fc, r.Address, r.lenOrVal)
+	case OPCODE_WRITE_COIL:
+		return fmt.Sprintf("%s addr=%d value=%t", fc, r.Address, r.lenOrVal == 0xFF00)
+	case OPCODE_WRITE_REGISTER:
+		return fmt.Sprintf("%s addr=%d value=%d", fc, r.Address, r.lenOrVal)
+	case OPCODE_WRITE_COILS:
+		return fmt.Sprintf("%s addr=%d qty=%d values=%s", fc, r.Address, r.lenOrVal, describeBits(r.payload, int(r.lenOrVal)))
+	case OPCODE_WRITE_REGISTERS:
+		return fmt.Sprintf("%s addr=%d qty=%d values=%s", fc, r.Address, r.lenOrVal, describeRegisters(r.payload))
+	case OPCODE_MASK_WRITE_REGISTER:
+		return fmt.Sprintf("%s addr=%d and=0x%04x or=0x%04x", fc, r.Address, r.andMask, r.orMask)
+	case OPCODE_READ_WRITE_REGISTERS:
+		return fmt.Sprintf("%s read=%d qty=%d write=%d values=%s", fc, r.Address, r.lenOrVal, r.WriteAddress, describeRegisters(r.payload))
+	case OPCODE_DIAGNOSTICS:
+		return fmt.Sprintf("%s sub=%d data=0x%04x", fc, r.Address, r.lenOrVal)
+	case OPCODE_ENCAPSULATED_INTERFACE:
+		return fmt.Sprintf("%s mei=0x%02x code=%d object=0x%02x", fc, r.mei, r.lenOrVal>>8, r.lenOrVal&0xFF)
+	case OPCODE_READ_FILE_RECORD, OPCODE_WRITE_FILE_RECORD:
+		return fmt.Sprintf("%s records=%s", fc, describeRecords(r.records))
+	}
+
+	return fc
+}
+
+func describeReply(r *Reply) string {
+	if err := r.Exception(); err != nil {
+		return fmt.Sprintf("fc=0x%02x %v", r.opcode, err)
+	}
+
+	fc := fmt.Sprintf("fc=0x%02x", r.opcode)
+
+	switch r.opcode {
+	case OPCODE_READ_COILS, OPCODE_DISCRETE_INPUTS:
+		return fmt.Sprintf("%s values=%s", fc, describeBits(r.payload, -1))
+	case OPCODE_READ_HOLDING_REGISTERS, OPCODE_READ_INPUT_REGISTERS, OPCODE_READ_WRITE_REGISTERS:
+		return fmt.Sprintf("%s values=%s", fc, describeRegisters(r.payload))
+	case OPCODE_WRITE_COIL:
+		return fmt.Sprintf("%s addr=%d value=%t", fc, r.Address, r.lenOrVal == 0xFF00)
+	case OPCODE_WRITE_REGISTER, OPCODE_WRITE_COILS, OPCODE_WRITE_REGISTERS:
+		return fmt.Sprintf("%s addr=%d value=%d", fc, r.Address, r.lenOrVal)
+	case OPCODE_MASK_WRITE_REGISTER:
+		return fmt.Sprintf("%s addr=%d and=0x%04x or=0x%04x", fc, r.Address, r.andMask, r.orMask)
+	case OPCODE_DIAGNOSTICS:
+		return fmt.Sprintf("%s sub=%d data=0x%04x", fc, r.Address, r.lenOrVal)
+	case OPCODE_ENCAPSULATED_INTERFACE:
+		objects := make([]string, len(r.objects))
+		for i, o := range r.objects {
+			objects[i] = fmt.Sprintf("0x%02x=%q", o.ID, o.Value)
+		}
+		return fmt.Sprintf("%s conformity=0x%02x more=%t objects=[%s]", fc, r.conformity, r.more, strings.Join(objects, " "))
+	case OPCODE_READ_FILE_RECORD, OPCODE_WRITE_FILE_RECORD:
+		return fmt.Sprintf("%s records=%s", fc, describeRecords(r.records))
+	}
+
+	return fc
+}
+
+func describeRecords(records []FileRecord) string {
+	values := make([]string, len(records))
+	for i, rec := range records {
+		values[i] = fmt.Sprintf("%d:%d+%d", rec.File, rec.Record, rec.Length)
+	}
+	return "[" + strings.Join(values, " ") + "]"
+}
+
+// CaptureDecoder turns capture records back into frames. TX records are
+// decoded as requests and RX records as replies, so feed it a capture taken
+// on the master side.
+type CaptureDecoder struct {
+	parser  Parser
+	tx      Buffer
+	rx      Buffer
+	last    time.Time
+	skipped []byte
+	dir     string
+}
+
+func NewCaptureDecoder(parser Parser) *CaptureDecoder {
+	return &CaptureDecoder{
+		parser: parser,
+		tx:     NewBuffer(FRAME_MAX),
+		rx:     NewBuffer(FRAME_MAX),
+	}
+}
+
+func (d *CaptureDecoder) Feed(rec *CaptureRecord) ([]Frame, error) {
+	data, err := hex.DecodeString(rec.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var in, other Buffer
+	var otherDir string
+	switch rec.Dir {
+	case CAPTURE_TX:
+		in, other, otherDir = d.tx, d.rx, CAPTURE_RX
+	case CAPTURE_RX:
+		in, other, otherDir = d.rx, d.tx, CAPTURE_TX
+	default:
+		return nil, fmt.Errorf("capture: unknown direction %q", rec.Dir)
+	}
+
+	// A new transfer in one direction ends whatever the other one left.
+	frames := d.flush(other, otherDir)
+	d.last = rec.Time
+
+	// A record can be longer than the buffer has room for, so it goes in a
+	// piece at a time. When nothing fits and nothing decodes, the oldest byte
+	// is given up to make room.
+	for len(data) > 0 {
+		n := len(data)
+		if free := in.Free(); n > free {
+			n = free
+		}
+
+		if n > 0 {
+			in.Write(data[:n])
+			data = data[n:]
+		}
+
+		frames = append(frames, d.decode(in, rec.Dir)...)
+		if in.IsFull() || n == 0 {
+			d.skip(in, 1, rec.Dir)
+		}
+	}
+
+	return frames, nil
+}
+
+// Flush reports anything still buffered at the end of a capture.
+func (d *CaptureDecoder) Flush() []Frame {
+	frames := d.flush(d.tx, CAPTURE_TX)
+	frames = append(frames, d.flush(d.rx, CAPTURE_RX)...)
+	return append(frames, d.skippedFrame()...)
+}
+
+func (d *CaptureDecoder) flush(b Buffer, dir string) []Frame {
+	frames := d.skippedFrame()
+	if b.IsEmpty() {
+		return frames
+	}
+
+	raw := make([]byte, b.Length())
+	b.Read(raw)
+	return append(frames, Frame{Time: d.last, Dir: dir, Raw: raw, Err: ErrShortFrame})
+}
+
+func (d *CaptureDecoder) skip(b Buffer, n int, dir string) {
+	raw := make([]byte, n)
+	n = b.Read(raw)
+	d.skipped = append(d.skipped, raw[:n]...)
+	d.dir = dir
+}
+
+func (d *CaptureDecoder) skippedFrame() []Frame {
+	if len(d.skipped) == 0 {
+		return nil
+	}
+
+	f := Frame{Time: d.last, Dir: d.dir, Raw: d.skipped, Err: ErrChecksum}
+	d.skipped = nil
+	return []Frame{f}
+}
+
+func (d *CaptureDecoder) decode(b Buffer, dir string) []Frame {
+	var frames []Frame
+
+	for !b.IsEmpty() {
+		peek := b.Clone()
+		before := b.Length()
+		f := Frame{Time: d.last, Dir: dir}
+
+		var err error
+		if dir == CAPTURE_TX {
+			f.Request = &Request{}
+			err = d.parser.DecodeRequest(&f.Unit, f.Request, b)
+		} else {
+			f.Reply = &Reply{}
+			err = d.parser.Decode(&f.Unit, f.Reply, b)
+		}
+
+		used := before - b.Length()
+		if errors.Is(err, ErrChecksum) {
+			d.skip(peek, used, dir)
+			continue
+		}
+
+		if err != nil {
+			break
+		}
+
+		frames = append(frames, d.skippedFrame()...)
+		f.Raw = make([]byte, used)
+		peek.Read(f.Raw)
+		frames = append(frames, f)
+	}
+
+	return frames
+}
@@ -0,0 +1,135 @@
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureDriver(t *testing.T) {
+	out := &bytes.Buffer{}
+	d := &memDriver{respond: reply(rtuFrame(0x01, 0x03, 0x02, 0x00, 0x05))}
+	d.timeout = POLL_INTERVAL
+
+	m := New(NewCaptureDriver(d, out), NewRTUParser())
+	m.SetTimeout(100 * time.Millisecond)
+	m.Open()
+
+	if _, err := m.ReadHoldingRegisters(context.Background(), 1, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []CaptureRecord{
+		{Dir: CAPTURE_TX, Data: hex.EncodeToString(rtuFrame(0x01, 0x03, 0x00, 0x00, 0x00, 0x01))},
+		{Dir: CAPTURE_RX, Data: hex.EncodeToString(rtuFrame(0x01, 0x03, 0x02, 0x00, 0x05))},
+	}
+
+	lines := bufio.NewScanner(out)
+	for i, w := range want {
+		if !lines.Scan() {
+			t.Fatalf("capture has %d records, want %d", i, len(want))
+		}
+
+		rec := CaptureRecord{}
+		if err := json.Unmarshal(lines.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+
+		if rec.Dir != w.Dir || rec.Data != w.Data || rec.Time.IsZero() {
+			t.Fatalf("record %d = %+v, want %+v", i, rec, w)
+		}
+	}
+}
+
+func TestCaptureDecoder(t *testing.T) {
+	request := rtuFrame(0x01, 0x03, 0x00, 0x00, 0x00, 0x02)
+	answer := rtuFrame(0x01, 0x03, 0x04, 0x00, 0x05, 0x00, 0x06)
+	write := rtuFrame(0x01, 0x10, 0x00, 0x0A, 0x00, 0x02, 0x04, 0x00, 0x01, 0x00, 0x02)
+
+	// Three full size replies take more room than the decoder buffers.
+	regs := make([]byte, 3+250)
+	copy(regs, []byte{0x01, 0x03, 0xFA})
+	big := rtuFrame(regs...)
+	big = append(append(big, big...), big...)
+	values := "rx unit=1 fc=0x03 values=[" + strings.TrimSpace(strings.Repeat("0 ", 125)) + "]"
+
+	type record struct {
+		dir  string
+		data []byte
+	}
+
+	tests := []struct {
+		name    string
+		records []record
+		want    []string
+	}{
+		{"request and reply", []record{{CAPTURE_TX, request}, {CAPTURE_RX, answer}}, []string{
+			"tx unit=1 fc=0x03 addr=0 qty=2",
+			"rx unit=1 fc=0x03 values=[5 6]",
+		}},
+		{"reply in pieces", []record{{CAPTURE_RX, answer[:3]}, {CAPTURE_RX, answer[3:]}}, []string{
+			"rx unit=1 fc=0x03 values=[5 6]",
+		}},
+		{"write registers", []record{{CAPTURE_TX, write}}, []string{
+			"tx unit=1 fc=0x10 addr=10 qty=2 values=[1 2]",
+		}},
+		{"exception", []record{{CAPTURE_RX, rtuFrame(0x01, 0x83, 0x02)}}, []string{
+			"rx unit=1 fc=0x83 modbus: function 0x03 exception 0x02 (illegal data address)",
+		}},
+		{"cut off reply", []record{{CAPTURE_RX, answer[:5]}, {CAPTURE_TX, request}}, []string{
+			"rx modbus: short frame [01 03 04 00 05]",
+			"tx unit=1 fc=0x03 addr=0 qty=2",
+		}},
+		{"noise", []record{{CAPTURE_RX, append([]byte{0x55}, rtuFrame(0x81, 0x83, 0x02)...)}}, []string{
+			"rx modbus: checksum mismatch [55]",
+			"rx unit=129 fc=0x83 modbus: function 0x03 exception 0x02 (illegal data address)",
+		}},
+		{"oversized record", []record{{CAPTURE_RX, big[:3]}, {CAPTURE_RX, big[3:]}}, []string{
+			values, values, values,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewCaptureDecoder(NewRTUParser())
+
+			var frames []Frame
+			for _, r := range tt.records {
+				got, err := d.Feed(&CaptureRecord{Dir: r.dir, Data: hex.EncodeToString(r.data)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				frames = append(frames, got...)
+			}
+			frames = append(frames, d.Flush()...)
+
+			if len(frames) != len(tt.want) {
+				t.Fatalf("decoded %d frames, want %d: %v", len(frames), len(tt.want), frames)
+			}
+
+			for i, want := range tt.want {
+				// Drop the time stamp in front.
+				if got := frames[i].String(); got[strings.IndexByte(got, ' ')+1:] != want {
+					t.Fatalf("frame %d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptureDecoderErrors(t *testing.T) {
+	d := NewCaptureDecoder(NewRTUParser())
+
+	if _, err := d.Feed(&CaptureRecord{Dir: "up", Data: "00"}); err == nil {
+		t.Fatal("unknown direction accepted")
+	}
+
+	if _, err := d.Feed(&CaptureRecord{Dir: CAPTURE_TX, Data: "0g"}); err == nil {
+		t.Fatal("bad hex accepted")
+	}
+}
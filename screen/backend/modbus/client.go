@@ -0,0 +1,255 @@
+package modbus
+
+import "context"
+
+func checkRange(address uint16, quantity int) error {
+	if quantity == 0 {
+		return ErrQuantity
+	}
+
+	if int(address)+quantity > ADDRESS_MAX {
+		return ErrAddress
+	}
+
+	return nil
+}
+
+func chunkLength(opcode uint8, remain int) int {
+	if max := opcodeQuantityMax(opcode); remain > max {
+		return max
+	}
+
+	return remain
+}
+
+func (m *Modbus) readBits(ctx context.Context, opcode uint8, unit uint8, address uint16, quantity uint16) ([]bool, error) {
+	if err := checkRange(address, int(quantity)); err != nil {
+		return nil, err
+	}
+
+	ctx, release, err := m.hold(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	values := make([]bool, 0, quantity)
+	for len(values) < int(quantity) {
+		n := chunkLength(opcode, int(quantity)-len(values))
+
+		chunk, err := m.readBitsOnce(ctx, opcode, unit, address+uint16(len(values)), uint16(n))
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, chunk...)
+	}
+
+	return values, nil
+}
+
+func (m *Modbus) readBitsOnce(ctx context.Context, opcode uint8, unit uint8, address uint16, quantity uint16) ([]bool, error) {
+	req := NewRequest(opcode)
+	req.Address = address
+	req.SetLength(quantity)
+
+	rep, err := m.ExecContext(ctx, unit, req)
+	if err != nil {
+		return nil, err
+	}
+
+	pyd, ok := rep.Payload().(PayloadBit)
+	if !ok || int(rep.Length())*8 < int(quantity) {
+		return nil, ErrUnexpected
+	}
+
+	values := make([]bool, quantity)
+	for i := range values {
+		values[i] = pyd.Get(i)
+	}
+
+	return values, nil
+}
+
+func (m *Modbus) readRegisters(ctx context.Context, opcode uint8, unit uint8, address uint16, quantity uint16) ([]uint16, error) {
+	if err := checkRange(address, int(quantity)); err != nil {
+		return nil, err
+	}
+
+	ctx, release, err := m.hold(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	values := make([]uint16, 0, quantity)
+	for len(values) < int(quantity) {
+		n := chunkLength(opcode, int(quantity)-len(values))
+
+		chunk, err := m.readRegistersOnce(ctx, opcode, unit, address+uint16(len(values)), uint16(n))
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, chunk...)
+	}
+
+	return values, nil
+}
+
+func (m *Modbus) readRegistersOnce(ctx context.Context, opcode uint8, unit uint8, address uint16, quantity uint16) ([]uint16, error) {
+	req := NewRequest(opcode)
+	req.Address = address
+	req.SetLength(quantity)
+
+	return m.registers(ctx, unit, req)
+}
+
+func (m *Modbus) registers(ctx context.Context, unit uint8, req *Request) ([]uint16, error) {
+	rep, err := m.ExecContext(ctx, unit, req)
+	if err != nil {
+		return nil, err
+	}
+
+	pyd, ok := rep.Payload().(PayloadU16)
+	if !ok || rep.Length() != req.lenOrVal*2 {
+		return nil, ErrUnexpected
+	}
+
+	values := make([]uint16, req.lenOrVal)
+	for i := range values {
+		values[i] = pyd.Get(i)
+	}
+
+	return values, nil
+}
+
+func (m *Modbus) write(ctx context.Context, unit uint8, req *Request) error {
+	_, err := m.ExecContext(ctx, unit, req)
+	return err
+}
+
+func (m *Modbus) ReadCoils(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]bool, error) {
+	return m.readBits(ctx, OPCODE_READ_COILS, unit, address, quantity)
+}
+
+func (m *Modbus) ReadDiscreteInputs(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]bool, error) {
+	return m.readBits(ctx, OPCODE_DISCRETE_INPUTS, unit, address, quantity)
+}
+
+func (m *Modbus) ReadHoldingRegisters(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]uint16, error) {
+	return m.readRegisters(ctx, OPCODE_READ_HOLDING_REGISTERS, unit, address, quantity)
+}
+
+func (m *Modbus) ReadInputRegisters(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]uint16, error) {
+	return m.readRegisters(ctx, OPCODE_READ_INPUT_REGISTERS, unit, address, quantity)
+}
+
+func (m *Modbus) WriteSingleCoil(ctx context.Context, unit uint8, address uint16, value bool) error {
+	req := NewRequest(OPCODE_WRITE_COIL)
+	req.Address = address
+	req.SetValue(value)
+
+	return m.write(ctx, unit, req)
+}
+
+func (m *Modbus) WriteSingleRegister(ctx context.Context, unit uint8, address uint16, value uint16) error {
+	req := NewRequest(OPCODE_WRITE_REGISTER)
+	req.Address = address
+	req.SetLength(value)
+
+	return m.write(ctx, unit, req)
+}
+
+func (m *Modbus) WriteMultipleCoils(ctx context.Context, unit uint8, address uint16, values []bool) error {
+	if err := checkRange(address, len(values)); err != nil {
+		return err
+	}
+
+	ctx, release, err := m.hold(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for sent := 0; sent < len(values); {
+		n := chunkLength(OPCODE_WRITE_COILS, len(values)-sent)
+
+		req := NewRequest(OPCODE_WRITE_COILS)
+		req.Address = address + uint16(sent)
+		req.SetLength(uint16(n))
+
+		pyd := req.Payload().(PayloadBit)
+		for i, v := range values[sent : sent+n] {
+			pyd.Set(i, v)
+		}
+
+		if err := m.write(ctx, unit, req); err != nil {
+			return err
+		}
+
+		sent += n
+	}
+
+	return nil
+}
+
+func (m *Modbus) WriteMultipleRegisters(ctx context.Context, unit uint8, address uint16, values []uint16) error {
+	if err := checkRange(address, len(values)); err != nil {
+		return err
+	}
+
+	ctx, release, err := m.hold(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for sent := 0; sent < len(values); {
+		n := chunkLength(OPCODE_WRITE_REGISTERS, len(values)-sent)
+
+		req := NewRequest(OPCODE_WRITE_REGISTERS)
+		req.Address = address + uint16(sent)
+		req.SetLength(uint16(n))
+
+		pyd := req.Payload().(PayloadU16)
+		for i, v := range values[sent : sent+n] {
+			pyd.Set(i, v)
+		}
+
+		if err := m.write(ctx, unit, req); err != nil {
+			return err
+		}
+
+		sent += n
+	}
+
+	return nil
+}
+
+func (m *Modbus) MaskWriteRegister(ctx context.Context, unit uint8, address uint16, and uint16, or uint16) error {
+	req := NewRequest(OPCODE_MASK_WRITE_REGISTER)
+	req.Address = address
+	req.SetMask(and, or)
+
+	return m.write(ctx, unit, req)
+}
+
+func (m *Modbus) ReadWriteMultipleRegisters(ctx context.Context, unit uint8, readAddress uint16, readQuantity uint16, writeAddress uint16, values []uint16) ([]uint16, error) {
+	if len(values) > READ_WRITE_REGISTERS_MAX {
+		return nil, ErrQuantity
+	}
+
+	req := NewRequest(OPCODE_READ_WRITE_REGISTERS)
+	req.Address = readAddress
+	req.WriteAddress = writeAddress
+	req.SetLength(readQuantity)
+	req.SetWriteLength(uint16(len(values)))
+
+	pyd := req.Payload().(PayloadU16)
+	for i, v := range values {
+		pyd.Set(i, v)
+	}
+
+	return m.registers(ctx, unit, req)
+}
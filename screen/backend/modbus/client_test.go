@@ -0,0 +1,116 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// registerSlave answers register reads with each register's own address and
+// acknowledges multiple register writes.
+func registerSlave(req []byte) [][]byte {
+	address := int(req[2])<<8 | int(req[3])
+	quantity := int(req[4])<<8 | int(req[5])
+
+	if req[1] == OPCODE_WRITE_REGISTERS {
+		return [][]byte{rtuFrame(req[:6]...)}
+	}
+
+	raws := []byte{req[0], req[1], byte(quantity * 2)}
+	for i := 0; i < quantity; i++ {
+		raws = append(raws, byte((address+i)>>8), byte(address+i))
+	}
+	return [][]byte{rtuFrame(raws...)}
+}
+
+func TestChunking(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  uint16
+		quantity int
+		requests int
+		err      error
+	}{
+		{"single", 0, 10, 1, nil},
+		{"one full chunk", 0, WRITE_REGISTERS_MAX, 1, nil},
+		{"three chunks", 100, 300, 3, nil},
+		{"zero", 0, 0, 0, ErrQuantity},
+		{"past the end", 0xFFF0, 0x20, 0, ErrAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &memDriver{respond: registerSlave}
+			m := newTestModbus(d)
+
+			regs, err := m.ReadHoldingRegisters(context.Background(), 1, tt.address, uint16(tt.quantity))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("read = %v, want %v", err, tt.err)
+			}
+
+			if len(d.sent) != tt.requests {
+				t.Fatalf("sent %d requests, want %d", len(d.sent), tt.requests)
+			}
+
+			for i, v := range regs {
+				if v != tt.address+uint16(i) {
+					t.Fatalf("register %d = %d", i, v)
+				}
+			}
+
+			d.sent = nil
+			values := make([]uint16, tt.quantity)
+			if err := m.WriteMultipleRegisters(context.Background(), 1, tt.address, values); !errors.Is(err, tt.err) {
+				t.Fatalf("write = %v, want %v", err, tt.err)
+			}
+
+			if len(d.sent) != tt.requests {
+				t.Fatalf("sent %d write requests, want %d", len(d.sent), tt.requests)
+			}
+		})
+	}
+}
+
+func TestChunksNotInterleaved(t *testing.T) {
+	d := &memDriver{gap: 10 * time.Millisecond, respond: registerSlave}
+	m := newTestModbus(d)
+
+	done := make(chan error)
+	go func() {
+		_, err := m.ReadHoldingRegisters(context.Background(), 1, 0, 3*READ_REGISTERS_MAX)
+		done <- err
+	}()
+
+	// Wait for the first chunk to go out, then cut in with high priority.
+	for len(sentFrames(d)) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := m.ReadHoldingRegisters(WithPriority(context.Background(), PriorityHigh), 2, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	sent := sentFrames(d)
+	if len(sent) != 4 || sent[3][0] != 2 {
+		t.Fatalf("request units %v, want the chunks of unit 1 before unit 2", units(sent))
+	}
+}
+
+func sentFrames(d *memDriver) [][]byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([][]byte(nil), d.sent...)
+}
+
+func units(frames [][]byte) []byte {
+	out := make([]byte, len(frames))
+	for i, f := range frames {
+		out[i] = f[0]
+	}
+	return out
+}
@@ -0,0 +1,221 @@
+package modbus
+
+import (
+	"math"
+	"strings"
+)
+
+type ByteOrder uint8
+
+const (
+	ABCD ByteOrder = iota
+	CDAB
+	BADC
+	DCBA
+)
+
+func (o ByteOrder) wordSwap() bool {
+	return o == CDAB || o == DCBA
+}
+
+func (o ByteOrder) byteSwap() bool {
+	return o == BADC || o == DCBA
+}
+
+func (o ByteOrder) String() string {
+	switch o {
+	case ABCD:
+		return "ABCD"
+	case CDAB:
+		return "CDAB"
+	case BADC:
+		return "BADC"
+	case DCBA:
+		return "DCBA"
+	}
+
+	return "unknown"
+}
+
+func (o ByteOrder) get(regs []uint16, n int) uint64 {
+	val := uint64(0)
+
+	for i := 0; i < n; i++ {
+		w := i
+		if o.wordSwap() {
+			w = n - 1 - i
+		}
+
+		reg := regs[w]
+		if o.byteSwap() {
+			reg = (reg << 8) | (reg >> 8)
+		}
+
+		val = (val << 16) | uint64(reg)
+	}
+
+	return val
+}
+
+func (o ByteOrder) put(regs []uint16, n int, val uint64) {
+	for i := n - 1; i >= 0; i-- {
+		w := i
+		if o.wordSwap() {
+			w = n - 1 - i
+		}
+
+		reg := uint16(val)
+		if o.byteSwap() {
+			reg = (reg << 8) | (reg >> 8)
+		}
+
+		regs[w] = reg
+		val >>= 16
+	}
+}
+
+func (o ByteOrder) Uint32(regs []uint16) uint32 {
+	return uint32(o.get(regs, 2))
+}
+
+func (o ByteOrder) PutUint32(regs []uint16, v uint32) {
+	o.put(regs, 2, uint64(v))
+}
+
+func (o ByteOrder) Int32(regs []uint16) int32 {
+	return int32(o.Uint32(regs))
+}
+
+func (o ByteOrder) PutInt32(regs []uint16, v int32) {
+	o.PutUint32(regs, uint32(v))
+}
+
+func (o ByteOrder) Uint64(regs []uint16) uint64 {
+	return o.get(regs, 4)
+}
+
+func (o ByteOrder) PutUint64(regs []uint16, v uint64) {
+	o.put(regs, 4, v)
+}
+
+func (o ByteOrder) Int64(regs []uint16) int64 {
+	return int64(o.Uint64(regs))
+}
+
+func (o ByteOrder) PutInt64(regs []uint16, v int64) {
+	o.PutUint64(regs, uint64(v))
+}
+
+func (o ByteOrder) Float32(regs []uint16) float32 {
+	return math.Float32frombits(o.Uint32(regs))
+}
+
+func (o ByteOrder) PutFloat32(regs []uint16, v float32) {
+	o.PutUint32(regs, math.Float32bits(v))
+}
+
+func (o ByteOrder) Float64(regs []uint16) float64 {
+	return math.Float64frombits(o.Uint64(regs))
+}
+
+func (o ByteOrder) PutFloat64(regs []uint16, v float64) {
+	o.PutUint64(regs, math.Float64bits(v))
+}
+
+func (o ByteOrder) Bytes(regs []uint16) []byte {
+	raws := make([]byte, len(regs)*2)
+
+	for i, reg := range regs {
+		if o.byteSwap() {
+			reg = (reg << 8) | (reg >> 8)
+		}
+
+		raws[i*2] = uint8(reg >> 8)
+		raws[i*2+1] = uint8(reg)
+	}
+
+	return raws
+}
+
+func (o ByteOrder) PutBytes(regs []uint16, raws []byte) int {
+	n := 0
+
+	for i := range regs {
+		hi := uint8(0)
+		lo := uint8(0)
+
+		if i*2 < len(raws) {
+			hi = raws[i*2]
+			n++
+		}
+
+		if i*2+1 < len(raws) {
+			lo = raws[i*2+1]
+			n++
+		}
+
+		reg := (uint16(hi) << 8) | uint16(lo)
+		if o.byteSwap() {
+			reg = (reg << 8) | (reg >> 8)
+		}
+
+		regs[i] = reg
+	}
+
+	return n
+}
+
+func (o ByteOrder) Text(regs []uint16) string {
+	return strings.TrimRight(string(o.Bytes(regs)), "\x00")
+}
+
+func (o ByteOrder) PutText(regs []uint16, s string) int {
+	return o.PutBytes(regs, []byte(s))
+}
+
+func reverseWords(raws []byte) {
+	for i, j := 0, len(raws)-2; i < j; i, j = i+2, j-2 {
+		raws[i], raws[i+1], raws[j], raws[j+1] = raws[j], raws[j+1], raws[i], raws[i+1]
+	}
+}
+
+func (o ByteOrder) BCD(regs []uint16) (uint64, error) {
+	val := uint64(0)
+	raws := o.Bytes(regs)
+
+	if o.wordSwap() {
+		reverseWords(raws)
+	}
+
+	for _, b := range raws {
+		hi := b >> 4
+		lo := b & 0x0F
+		if hi > 9 || lo > 9 {
+			return 0, ErrValue
+		}
+
+		val = val*100 + uint64(hi)*10 + uint64(lo)
+	}
+
+	return val, nil
+}
+
+func (o ByteOrder) PutBCD(regs []uint16, v uint64) error {
+	raws := make([]byte, len(regs)*2)
+
+	for i := len(raws) - 1; i >= 0; i-- {
+		raws[i] = uint8(v%10) | uint8((v/10)%10)<<4
+		v /= 100
+	}
+
+	if v != 0 {
+		return ErrValue
+	}
+
+	if o.wordSwap() {
+		reverseWords(raws)
+	}
+
+	o.PutBytes(regs, raws)
+	return nil
+}
@@ -0,0 +1,114 @@
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+func sameWords(a []uint16, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestByteOrder(t *testing.T) {
+	orders := []ByteOrder{ABCD, CDAB, BADC, DCBA}
+
+	tests := []struct {
+		name string
+		put  func(o ByteOrder, regs []uint16)
+		get  func(o ByteOrder, regs []uint16) bool
+		want [4][]uint16
+	}{
+		{
+			"uint32",
+			func(o ByteOrder, regs []uint16) { o.PutUint32(regs, 0x11223344) },
+			func(o ByteOrder, regs []uint16) bool { return o.Uint32(regs) == 0x11223344 },
+			[4][]uint16{{0x1122, 0x3344}, {0x3344, 0x1122}, {0x2211, 0x4433}, {0x4433, 0x2211}},
+		},
+		{
+			"int32",
+			func(o ByteOrder, regs []uint16) { o.PutInt32(regs, -2) },
+			func(o ByteOrder, regs []uint16) bool { return o.Int32(regs) == -2 },
+			[4][]uint16{{0xFFFF, 0xFFFE}, {0xFFFE, 0xFFFF}, {0xFFFF, 0xFEFF}, {0xFEFF, 0xFFFF}},
+		},
+		{
+			"float32",
+			func(o ByteOrder, regs []uint16) { o.PutFloat32(regs, 1) },
+			func(o ByteOrder, regs []uint16) bool { return o.Float32(regs) == 1 },
+			[4][]uint16{{0x3F80, 0x0000}, {0x0000, 0x3F80}, {0x803F, 0x0000}, {0x0000, 0x803F}},
+		},
+		{
+			"uint64",
+			func(o ByteOrder, regs []uint16) { o.PutUint64(regs, 0x0102030405060708) },
+			func(o ByteOrder, regs []uint16) bool { return o.Uint64(regs) == 0x0102030405060708 },
+			[4][]uint16{
+				{0x0102, 0x0304, 0x0506, 0x0708},
+				{0x0708, 0x0506, 0x0304, 0x0102},
+				{0x0201, 0x0403, 0x0605, 0x0807},
+				{0x0807, 0x0605, 0x0403, 0x0201},
+			},
+		},
+		{
+			"float64",
+			func(o ByteOrder, regs []uint16) { o.PutFloat64(regs, 1) },
+			func(o ByteOrder, regs []uint16) bool { return o.Float64(regs) == 1 },
+			[4][]uint16{
+				{0x3FF0, 0x0000, 0x0000, 0x0000},
+				{0x0000, 0x0000, 0x0000, 0x3FF0},
+				{0xF03F, 0x0000, 0x0000, 0x0000},
+				{0x0000, 0x0000, 0x0000, 0xF03F},
+			},
+		},
+		{
+			"bcd",
+			func(o ByteOrder, regs []uint16) { o.PutBCD(regs, 12345678) },
+			func(o ByteOrder, regs []uint16) bool {
+				v, err := o.BCD(regs)
+				return err == nil && v == 12345678
+			},
+			[4][]uint16{{0x1234, 0x5678}, {0x5678, 0x1234}, {0x3412, 0x7856}, {0x7856, 0x3412}},
+		},
+		{
+			"text",
+			func(o ByteOrder, regs []uint16) { o.PutText(regs, "ABC") },
+			func(o ByteOrder, regs []uint16) bool { return o.Text(regs) == "ABC" },
+			[4][]uint16{{0x4142, 0x4300}, {0x4142, 0x4300}, {0x4241, 0x0043}, {0x4241, 0x0043}},
+		},
+	}
+
+	for _, tt := range tests {
+		for i, o := range orders {
+			t.Run(tt.name+"/"+o.String(), func(t *testing.T) {
+				regs := make([]uint16, len(tt.want[i]))
+				tt.put(o, regs)
+
+				if !sameWords(regs, tt.want[i]) {
+					t.Fatalf("put = %04x, want %04x", regs, tt.want[i])
+				}
+
+				if !tt.get(o, regs) {
+					t.Fatalf("get did not return the value put into %04x", regs)
+				}
+			})
+		}
+	}
+}
+
+func TestBCDErrors(t *testing.T) {
+	if _, err := ABCD.BCD([]uint16{0x1A00}); !errors.Is(err, ErrValue) {
+		t.Fatalf("BCD of a non decimal nibble = %v, want ErrValue", err)
+	}
+
+	if err := ABCD.PutBCD(make([]uint16, 2), 100000000); !errors.Is(err, ErrValue) {
+		t.Fatalf("PutBCD of nine digits into four bytes = %v, want ErrValue", err)
+	}
+}
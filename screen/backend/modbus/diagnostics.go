@@ -0,0 +1,137 @@
+package modbus
+
+import "context"
+
+type DeviceIdentification struct {
+	Conformity uint8
+	Objects    map[uint8]string
+}
+
+func (d *DeviceIdentification) Get(id uint8) string {
+	return d.Objects[id]
+}
+
+type DiagnosticCounters struct {
+	BusMessages       uint16
+	BusCommErrors     uint16
+	BusExceptions     uint16
+	SlaveMessages     uint16
+	SlaveNoResponse   uint16
+	SlaveNAK          uint16
+	SlaveBusy         uint16
+	CharacterOverruns uint16
+}
+
+func (m *Modbus) ReadDeviceIdentification(ctx context.Context, unit uint8, code uint8) (*DeviceIdentification, error) {
+	ident := &DeviceIdentification{
+		Objects: make(map[uint8]string),
+	}
+
+	object := uint8(OBJECT_VENDOR_NAME)
+	for {
+		req := NewRequest(OPCODE_ENCAPSULATED_INTERFACE)
+		req.SetDeviceID(code, object)
+
+		rep, err := m.ExecContext(ctx, unit, req)
+		if err != nil {
+			return nil, err
+		}
+
+		if rep.mei != MEI_READ_DEVICE_ID {
+			return nil, ErrUnexpected
+		}
+
+		ident.Conformity = rep.conformity
+		for _, obj := range rep.Objects() {
+			ident.Objects[obj.ID] = string(obj.Value)
+		}
+
+		if !rep.more || code == DEVICE_ID_INDIVIDUAL || rep.next <= object {
+			return ident, nil
+		}
+
+		object = rep.next
+	}
+}
+
+func (m *Modbus) ReadDeviceObject(ctx context.Context, unit uint8, id uint8) (string, error) {
+	req := NewRequest(OPCODE_ENCAPSULATED_INTERFACE)
+	req.SetDeviceID(DEVICE_ID_INDIVIDUAL, id)
+
+	rep, err := m.ExecContext(ctx, unit, req)
+	if err != nil {
+		return "", err
+	}
+
+	for _, obj := range rep.Objects() {
+		if obj.ID == id {
+			return string(obj.Value), nil
+		}
+	}
+
+	return "", ErrUnexpected
+}
+
+func (m *Modbus) Diagnostics(ctx context.Context, unit uint8, sub uint16, data uint16) (uint16, error) {
+	req := NewRequest(OPCODE_DIAGNOSTICS)
+	req.Address = sub
+	req.SetLength(data)
+
+	rep, err := m.ExecContext(ctx, unit, req)
+	if err != nil {
+		return 0, err
+	}
+
+	if rep.Address != sub {
+		return 0, ErrUnexpected
+	}
+
+	return rep.Length(), nil
+}
+
+func (m *Modbus) Echo(ctx context.Context, unit uint8, data uint16) error {
+	val, err := m.Diagnostics(ctx, unit, DIAG_RETURN_QUERY_DATA, data)
+	if err != nil {
+		return err
+	}
+
+	if val != data {
+		return ErrUnexpected
+	}
+
+	return nil
+}
+
+func (m *Modbus) ClearDiagnosticCounters(ctx context.Context, unit uint8) error {
+	_, err := m.Diagnostics(ctx, unit, DIAG_CLEAR_COUNTERS, 0)
+	return err
+}
+
+func (m *Modbus) ReadDiagnosticCounters(ctx context.Context, unit uint8) (*DiagnosticCounters, error) {
+	c := &DiagnosticCounters{}
+
+	counters := []struct {
+		sub uint16
+		val *uint16
+	}{
+		{DIAG_BUS_MESSAGE_COUNT, &c.BusMessages},
+		{DIAG_BUS_COMM_ERROR_COUNT, &c.BusCommErrors},
+		{DIAG_BUS_EXCEPTION_ERROR_COUNT, &c.BusExceptions},
+		{DIAG_SLAVE_MESSAGE_COUNT, &c.SlaveMessages},
+		{DIAG_SLAVE_NO_RESPONSE_COUNT, &c.SlaveNoResponse},
+		{DIAG_SLAVE_NAK_COUNT, &c.SlaveNAK},
+		{DIAG_SLAVE_BUSY_COUNT, &c.SlaveBusy},
+		{DIAG_BUS_CHARACTER_OVERRUN, &c.CharacterOverruns},
+	}
+
+	for _, counter := range counters {
+		val, err := m.Diagnostics(ctx, unit, counter.sub, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		*counter.val = val
+	}
+
+	return c, nil
+}
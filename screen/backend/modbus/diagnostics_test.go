@@ -0,0 +1,232 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+// The identification of one screen, split over two replies the way a slave
+// does when the objects do not fit in one.
+var (
+	identFirst = []byte{
+		0x2B, 0x0E, 0x03, 0x83, 0xFF, 0x02, 0x02,
+		0x00, 0x07, 'c', 'o', 'o', 'r', 'i', 'f', 'y',
+		0x01, 0x06, 'N', 'A', 'S', '-', 'S', '1',
+	}
+	identLast = []byte{
+		0x2B, 0x0E, 0x03, 0x83, 0x00, 0x00, 0x02,
+		0x02, 0x03, '1', '.', '4',
+		0x80, 0x04, '0', '0', '4', '2',
+	}
+)
+
+func TestDeviceIDReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		pdu     []byte
+		more    bool
+		next    uint8
+		objects []DeviceObject
+	}{
+		{"first part", identFirst, true, 0x02, []DeviceObject{{0x00, []byte("coorify")}, {0x01, []byte("NAS-S1")}}},
+		{"last part", identLast, false, 0x00, []DeviceObject{{0x02, []byte("1.4")}, {0x80, []byte("0042")}}},
+		{"no objects", []byte{0x2B, 0x0E, 0x04, 0x01, 0x00, 0x00, 0x00}, false, 0x00, []DeviceObject{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep := &Reply{}
+			if !rep.decode(bufferOf(tt.pdu).(*_buffer)) {
+				t.Fatal("decode failed")
+			}
+
+			if rep.more != tt.more || rep.next != tt.next {
+				t.Fatalf("more %t next 0x%02x, want %t and 0x%02x", rep.more, rep.next, tt.more, tt.next)
+			}
+
+			if fmt.Sprint(rep.Objects()) != fmt.Sprint(tt.objects) {
+				t.Fatalf("objects %v, want %v", rep.Objects(), tt.objects)
+			}
+
+			b := NewBuffer(FRAME_MAX)
+			if !rep.encode(b.(*_buffer)) {
+				t.Fatal("encode failed")
+			}
+
+			if got := bytesOf(b); !bytes.Equal(got, tt.pdu) {
+				t.Fatalf("encode = % x, want % x", got, tt.pdu)
+			}
+		})
+	}
+}
+
+func TestDeviceIDReplyTruncated(t *testing.T) {
+	for n := 3; n < len(identFirst); n++ {
+		rep := &Reply{}
+		if rep.decode(bufferOf(identFirst[:n]).(*_buffer)) {
+			t.Fatalf("decoded %d of %d bytes", n, len(identFirst))
+		}
+	}
+}
+
+// identSlave answers Read Device Identification from parts, keyed by the
+// object id asked for.
+func identSlave(parts map[uint8][]byte) func(req []byte) [][]byte {
+	return func(req []byte) [][]byte {
+		pdu, ok := parts[req[4]]
+		if !ok {
+			return [][]byte{rtuFrame(req[0], 0xAB, EXCEPTION_ILLEGAL_DATA_ADDRESS)}
+		}
+		return [][]byte{rtuFrame(append([]byte{req[0]}, pdu...)...)}
+	}
+}
+
+func TestReadDeviceIdentification(t *testing.T) {
+	stuck := append([]byte{}, identFirst...)
+	stuck[5] = 0x00
+
+	// A CANopen General Reference reply, which carries no objects.
+	otherMEI := []byte{0x2B, 0x0D}
+
+	tests := []struct {
+		name    string
+		code    uint8
+		parts   map[uint8][]byte
+		objects map[uint8]string
+		asked   []uint8
+		err     error
+	}{
+		{"two parts", DEVICE_ID_EXTENDED, map[uint8][]byte{0x00: identFirst, 0x02: identLast}, map[uint8]string{
+			0x00: "coorify", 0x01: "NAS-S1", 0x02: "1.4", 0x80: "0042",
+		}, []uint8{0x00, 0x02}, nil},
+		{"next not ahead", DEVICE_ID_EXTENDED, map[uint8][]byte{0x00: stuck}, map[uint8]string{
+			0x00: "coorify", 0x01: "NAS-S1",
+		}, []uint8{0x00}, nil},
+		{"individual", DEVICE_ID_INDIVIDUAL, map[uint8][]byte{0x00: identFirst}, map[uint8]string{
+			0x00: "coorify", 0x01: "NAS-S1",
+		}, []uint8{0x00}, nil},
+		{"missing part", DEVICE_ID_EXTENDED, map[uint8][]byte{0x00: identFirst}, nil, []uint8{0x00, 0x02}, &ExceptionError{Function: OPCODE_ENCAPSULATED_INTERFACE, Code: EXCEPTION_ILLEGAL_DATA_ADDRESS}},
+		{"other mei", DEVICE_ID_EXTENDED, map[uint8][]byte{0x00: otherMEI}, nil, []uint8{0x00}, ErrUnexpected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &memDriver{respond: identSlave(tt.parts)}
+			m := newTestModbus(d)
+
+			id, err := m.ReadDeviceIdentification(context.Background(), 1, tt.code)
+			if fmt.Sprint(err) != fmt.Sprint(tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+
+			if tt.err == nil && (fmt.Sprint(id.Objects) != fmt.Sprint(tt.objects) || id.Conformity != 0x83) {
+				t.Fatalf("objects %v conformity 0x%02x, want %v", id.Objects, id.Conformity, tt.objects)
+			}
+
+			var asked []uint8
+			for _, req := range d.sent {
+				if req[1] != OPCODE_ENCAPSULATED_INTERFACE || req[2] != MEI_READ_DEVICE_ID || req[3] != tt.code {
+					t.Fatalf("request % x", req)
+				}
+				asked = append(asked, req[4])
+			}
+
+			if !bytes.Equal(asked, tt.asked) {
+				t.Fatalf("asked for objects % x, want % x", asked, tt.asked)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsPDU(t *testing.T) {
+	// Return Query Data, the example of the specification.
+	echo := NewRequest(OPCODE_DIAGNOSTICS)
+	echo.Address = DIAG_RETURN_QUERY_DATA
+	echo.SetLength(0xA537)
+	checkPDU(t, echo, []byte{0x08, 0x00, 0x00, 0xA5, 0x37})
+
+	ident := NewRequest(OPCODE_ENCAPSULATED_INTERFACE)
+	ident.SetDeviceID(DEVICE_ID_BASIC, OBJECT_VENDOR_NAME)
+	checkPDU(t, ident, []byte{0x2B, 0x0E, 0x01, 0x00})
+
+	rep := &Reply{}
+	if !rep.decode(bufferOf([]byte{0x08, 0x00, 0x0B, 0x01, 0x2C}).(*_buffer)) {
+		t.Fatal("decode failed")
+	}
+
+	if rep.Address != DIAG_BUS_MESSAGE_COUNT || rep.Length() != 300 {
+		t.Fatalf("sub 0x%04x data %d", rep.Address, rep.Length())
+	}
+}
+
+// diagSlave answers Diagnostics with data for Return Query Data and with
+// three times the sub-function for the counters.
+func diagSlave(req []byte) [][]byte {
+	sub := uint16(req[2])<<8 | uint16(req[3])
+	data := uint16(req[4])<<8 | uint16(req[5])
+
+	switch {
+	case sub == DIAG_RETURN_QUERY_DATA && data == 0xDEAD:
+		data = 0xBEEF
+	case sub >= DIAG_BUS_MESSAGE_COUNT && sub <= DIAG_BUS_CHARACTER_OVERRUN:
+		data = sub * 3
+	case sub == 0x00FF:
+		return [][]byte{rtuFrame(req[0], 0x88, EXCEPTION_ILLEGAL_FUNCTION)}
+	}
+
+	return [][]byte{rtuFrame(req[0], req[1], req[2], req[3], byte(data>>8), byte(data))}
+}
+
+func TestDiagnostics(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func(m *Modbus) error
+		subs []uint16
+		err  error
+	}{
+		{"echo", func(m *Modbus) error { return m.Echo(ctx, 1, 0xA537) }, []uint16{DIAG_RETURN_QUERY_DATA}, nil},
+		{"echo garbled", func(m *Modbus) error { return m.Echo(ctx, 1, 0xDEAD) }, []uint16{DIAG_RETURN_QUERY_DATA}, ErrUnexpected},
+		{"clear counters", func(m *Modbus) error { return m.ClearDiagnosticCounters(ctx, 1) }, []uint16{DIAG_CLEAR_COUNTERS}, nil},
+		{"counters", func(m *Modbus) error {
+			c, err := m.ReadDiagnosticCounters(ctx, 1)
+			if err != nil {
+				return err
+			}
+
+			want := DiagnosticCounters{33, 36, 39, 42, 45, 48, 51, 54}
+			if *c != want {
+				return fmt.Errorf("counters %+v, want %+v", *c, want)
+			}
+			return nil
+		}, []uint16{0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10, 0x11, 0x12}, nil},
+		{"not supported", func(m *Modbus) error {
+			_, err := m.Diagnostics(ctx, 1, 0x00FF, 0)
+			return err
+		}, []uint16{0x00FF}, &ExceptionError{Function: OPCODE_DIAGNOSTICS, Code: EXCEPTION_ILLEGAL_FUNCTION}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &memDriver{respond: diagSlave}
+			m := newTestModbus(d)
+
+			err := tt.call(m)
+			if fmt.Sprint(err) != fmt.Sprint(tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+
+			var subs []uint16
+			for _, req := range d.sent {
+				subs = append(subs, uint16(req[2])<<8|uint16(req[3]))
+			}
+
+			if fmt.Sprint(subs) != fmt.Sprint(tt.subs) {
+				t.Fatalf("sub-functions %x, want %x", subs, tt.subs)
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package modbus
 
+import "time"
+
 type Driver interface {
 	Open() error
 	Close() error
@@ -7,3 +9,19 @@ type Driver interface {
 	Read([]byte) (int, error)
 	Write([]byte) (int, error)
 }
+
+type baudDriver interface {
+	BaudRate() int
+}
+
+type timeoutDriver interface {
+	SetReadTimeout(t time.Duration) error
+}
+
+// floorDriver is implemented by drivers behind an adapter that hands data
+// over in bursts, as USB serial adapters do. A gap on the line shorter than
+// the floor says nothing about where a frame ends, so t1.5 and t3.5 are
+// raised to it.
+type floorDriver interface {
+	SilenceFloor() time.Duration
+}
@@ -0,0 +1,83 @@
+package modbus
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var ErrConnNotOpen = errors.New("modbus: connection not open")
+
+type _tcpDriver struct {
+	address string
+	timeout time.Duration
+	reading time.Duration
+	conn    net.Conn
+}
+
+func NewTCPDriver(address string) Driver {
+	return &_tcpDriver{
+		address: address,
+		timeout: 1 * time.Second,
+		reading: 1 * time.Second,
+		conn:    nil,
+	}
+}
+
+func newConnDriver(conn net.Conn) Driver {
+	return &_tcpDriver{
+		address: conn.RemoteAddr().String(),
+		timeout: 1 * time.Second,
+		reading: 1 * time.Second,
+		conn:    conn,
+	}
+}
+
+func (d *_tcpDriver) Open() error {
+	conn, err := net.DialTimeout("tcp", d.address, d.timeout)
+	if err != nil {
+		return err
+	}
+
+	d.conn = conn
+	return nil
+}
+
+func (d *_tcpDriver) Close() error {
+	if d.conn == nil {
+		return ErrConnNotOpen
+	}
+
+	err := d.conn.Close()
+	d.conn = nil
+	return err
+}
+
+func (d *_tcpDriver) SetReadTimeout(t time.Duration) error {
+	d.reading = t
+	return nil
+}
+
+func (d *_tcpDriver) Read(p []byte) (int, error) {
+	if d.conn == nil {
+		return 0, ErrConnNotOpen
+	}
+
+	d.conn.SetReadDeadline(time.Now().Add(d.reading))
+
+	n, err := d.conn.Read(p)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return n, nil
+	}
+
+	return n, err
+}
+
+func (d *_tcpDriver) Write(p []byte) (int, error) {
+	if d.conn == nil {
+		return 0, ErrConnNotOpen
+	}
+
+	d.conn.SetWriteDeadline(time.Now().Add(d.timeout))
+	return d.conn.Write(p)
+}
@@ -0,0 +1,64 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	EXCEPTION_ILLEGAL_FUNCTION        = 0x01
+	EXCEPTION_ILLEGAL_DATA_ADDRESS    = 0x02
+	EXCEPTION_ILLEGAL_DATA_VALUE      = 0x03
+	EXCEPTION_SLAVE_DEVICE_FAILURE    = 0x04
+	EXCEPTION_ACKNOWLEDGE             = 0x05
+	EXCEPTION_SLAVE_DEVICE_BUSY       = 0x06
+	EXCEPTION_MEMORY_PARITY_ERROR     = 0x08
+	EXCEPTION_GATEWAY_PATH_UNAVAIL    = 0x0A
+	EXCEPTION_GATEWAY_TARGET_NO_REPLY = 0x0B
+)
+
+var (
+	ErrEncode     = errors.New("modbus: encode failed")
+	ErrShortFrame = errors.New("modbus: short frame")
+	ErrChecksum   = errors.New("modbus: checksum mismatch")
+	ErrTimeout    = errors.New("modbus: reply timeout")
+	ErrUnexpected = errors.New("modbus: unexpected reply")
+	ErrQuantity   = errors.New("modbus: quantity out of range")
+	ErrAddress    = errors.New("modbus: address out of range")
+	ErrValue      = errors.New("modbus: invalid value")
+	ErrBroadcast  = errors.New("modbus: function not allowed for broadcast")
+)
+
+type ExceptionError struct {
+	Function uint8
+	Code     uint8
+}
+
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("modbus: function 0x%02X exception 0x%02X (%s)", e.Function, e.Code, exceptionText(e.Code))
+}
+
+func exceptionText(code uint8) string {
+	switch code {
+	case EXCEPTION_ILLEGAL_FUNCTION:
+		return "illegal function"
+	case EXCEPTION_ILLEGAL_DATA_ADDRESS:
+		return "illegal data address"
+	case EXCEPTION_ILLEGAL_DATA_VALUE:
+		return "illegal data value"
+	case EXCEPTION_SLAVE_DEVICE_FAILURE:
+		return "slave device failure"
+	case EXCEPTION_ACKNOWLEDGE:
+		return "acknowledge"
+	case EXCEPTION_SLAVE_DEVICE_BUSY:
+		return "slave device busy"
+	case EXCEPTION_MEMORY_PARITY_ERROR:
+		return "memory parity error"
+	case EXCEPTION_GATEWAY_PATH_UNAVAIL:
+		return "gateway path unavailable"
+	case EXCEPTION_GATEWAY_TARGET_NO_REPLY:
+		return "gateway target device failed to respond"
+	}
+
+	return "unknown"
+}
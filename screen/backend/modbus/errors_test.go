@@ -0,0 +1,52 @@
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExecErrors(t *testing.T) {
+	good := rtuFrame(0x01, 0x03, 0x02, 0x00, 0x05)
+	corrupt := append([]byte{}, good...)
+	corrupt[4] ^= 0xFF
+
+	tests := []struct {
+		name  string
+		reply [][]byte
+		err   error
+		code  uint8
+	}{
+		{"ok", [][]byte{good}, nil, 0},
+		{"exception", [][]byte{rtuFrame(0x01, 0x83, 0x02)}, &ExceptionError{}, EXCEPTION_ILLEGAL_DATA_ADDRESS},
+		{"busy", [][]byte{rtuFrame(0x01, 0x83, 0x06)}, &ExceptionError{}, EXCEPTION_SLAVE_DEVICE_BUSY},
+		{"no reply", nil, ErrTimeout, 0},
+		{"corrupt", [][]byte{corrupt}, ErrChecksum, 0},
+		{"other unit", [][]byte{rtuFrame(0x02, 0x03, 0x02, 0x00, 0x05)}, ErrUnexpected, 0},
+		{"other function", [][]byte{rtuFrame(0x01, 0x04, 0x02, 0x00, 0x05)}, ErrUnexpected, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModbus(&memDriver{respond: reply(tt.reply...)})
+
+			_, err := m.Exec(1, readRequest(0, 1))
+			if exc, ok := tt.err.(*ExceptionError); ok {
+				if !errors.As(err, &exc) || exc.Code != tt.code || exc.Function != OPCODE_READ_HOLDING_REGISTERS {
+					t.Fatalf("exec = %v, want exception 0x%02x", err, tt.code)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("exec = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestExceptionErrorText(t *testing.T) {
+	err := &ExceptionError{Function: 0x03, Code: EXCEPTION_ILLEGAL_DATA_ADDRESS}
+	if got, want := err.Error(), "modbus: function 0x03 exception 0x02 (illegal data address)"; got != want {
+		t.Fatalf("error = %q, want %q", got, want)
+	}
+}
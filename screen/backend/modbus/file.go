@@ -0,0 +1,261 @@
+package modbus
+
+import (
+	"context"
+	"io"
+)
+
+const (
+	FILE_READ_RECORD_MAX  = (FILE_DATA_MAX - 2) / 2
+	FILE_WRITE_RECORD_MAX = (FILE_DATA_MAX - 7) / 2
+)
+
+func splitRecords(records []FileRecord, max int) []FileRecord {
+	pieces := make([]FileRecord, 0, len(records))
+
+	for _, rec := range records {
+		for off := 0; off < int(rec.Length); off += max {
+			n := int(rec.Length) - off
+			if n > max {
+				n = max
+			}
+
+			piece := FileRecord{
+				File:   rec.File,
+				Record: rec.Record + uint16(off),
+				Length: uint16(n),
+			}
+
+			if rec.Data != nil {
+				piece.Data = rec.Data[off : off+n]
+			}
+
+			pieces = append(pieces, piece)
+		}
+	}
+
+	return pieces
+}
+
+func checkRecords(records []FileRecord) error {
+	if len(records) == 0 {
+		return ErrQuantity
+	}
+
+	for _, rec := range records {
+		if rec.Length == 0 {
+			return ErrQuantity
+		}
+
+		if rec.File == 0 || int(rec.Record)+int(rec.Length) > FILE_RECORD_MAX {
+			return ErrAddress
+		}
+	}
+
+	return nil
+}
+
+func (m *Modbus) ReadFileRecords(ctx context.Context, unit uint8, records []FileRecord) ([]FileRecord, error) {
+	if err := checkRecords(records); err != nil {
+		return nil, err
+	}
+
+	ctx, release, err := m.hold(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	pieces := splitRecords(records, FILE_READ_RECORD_MAX)
+	for start := 0; start < len(pieces); {
+		req := NewRequest(OPCODE_READ_FILE_RECORD)
+		request := 0
+		reply := 0
+
+		end := start
+		for end < len(pieces) {
+			p := &pieces[end]
+			if request+p.readSize() > FILE_REQUEST_MAX || reply+p.replySize() > FILE_DATA_MAX {
+				break
+			}
+
+			request += p.readSize()
+			reply += p.replySize()
+			req.AddRecord(*p)
+			end++
+		}
+
+		rep, err := m.ExecContext(ctx, unit, req)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rep.Records()) != end-start {
+			return nil, ErrUnexpected
+		}
+
+		for i, rec := range rep.Records() {
+			if rec.Length != pieces[start+i].Length {
+				return nil, ErrUnexpected
+			}
+
+			pieces[start+i].Data = rec.Data
+		}
+
+		start = end
+	}
+
+	result := make([]FileRecord, len(records))
+	for i, rec := range records {
+		result[i] = FileRecord{
+			File:   rec.File,
+			Record: rec.Record,
+			Length: rec.Length,
+			Data:   make([]uint16, 0, rec.Length),
+		}
+
+		for len(result[i].Data) < int(rec.Length) {
+			result[i].Data = append(result[i].Data, pieces[0].Data...)
+			pieces = pieces[1:]
+		}
+	}
+
+	return result, nil
+}
+
+func (m *Modbus) WriteFileRecords(ctx context.Context, unit uint8, records []FileRecord) error {
+	records = append([]FileRecord(nil), records...)
+	for i := range records {
+		records[i].Length = uint16(len(records[i].Data))
+	}
+
+	if err := checkRecords(records); err != nil {
+		return err
+	}
+
+	ctx, release, err := m.hold(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	pieces := splitRecords(records, FILE_WRITE_RECORD_MAX)
+	for start := 0; start < len(pieces); {
+		req := NewRequest(OPCODE_WRITE_FILE_RECORD)
+		size := 0
+
+		end := start
+		for end < len(pieces) && size+pieces[end].writeSize() <= FILE_DATA_MAX {
+			size += pieces[end].writeSize()
+			req.AddRecord(pieces[end])
+			end++
+		}
+
+		rep, err := m.ExecContext(ctx, unit, req)
+		if err != nil {
+			return err
+		}
+
+		if m.broadcast(unit) {
+			start = end
+			continue
+		}
+
+		if !sameRecords(req.Records(), rep.Records()) {
+			return ErrUnexpected
+		}
+
+		start = end
+	}
+
+	return nil
+}
+
+func sameRecords(a []FileRecord, b []FileRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].File != b[i].File || a[i].Record != b[i].Record || len(a[i].Data) != len(b[i].Data) {
+			return false
+		}
+
+		for j := range a[i].Data {
+			if a[i].Data[j] != b[i].Data[j] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (m *Modbus) ReadFile(ctx context.Context, unit uint8, file uint16, record uint16, length uint16) ([]uint16, error) {
+	recs, err := m.ReadFileRecords(ctx, unit, []FileRecord{{File: file, Record: record, Length: length}})
+	if err != nil {
+		return nil, err
+	}
+
+	return recs[0].Data, nil
+}
+
+func (m *Modbus) WriteFile(ctx context.Context, unit uint8, file uint16, record uint16, data []uint16) error {
+	return m.WriteFileRecords(ctx, unit, []FileRecord{{File: file, Record: record, Data: data}})
+}
+
+type FileTransfer struct {
+	mdb  *Modbus
+	unit uint8
+	file uint16
+}
+
+func NewFileTransfer(mdb *Modbus, unit uint8, file uint16) *FileTransfer {
+	return &FileTransfer{
+		mdb:  mdb,
+		unit: unit,
+		file: file,
+	}
+}
+
+func (t *FileTransfer) Write(ctx context.Context, r io.Reader) (int64, error) {
+	raws := make([]byte, FILE_WRITE_RECORD_MAX*2)
+	words := make([]uint16, FILE_WRITE_RECORD_MAX)
+	record := 0
+	written := int64(0)
+
+	for {
+		n, err := io.ReadFull(r, raws)
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return written, nil
+		}
+
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return written, err
+		}
+
+		if n%2 != 0 {
+			raws[n] = 0
+		}
+
+		count := (n + 1) / 2
+		if record+count > FILE_RECORD_MAX {
+			return written, ErrAddress
+		}
+
+		for i := 0; i < count; i++ {
+			words[i] = (uint16(raws[i*2]) << 8) | uint16(raws[i*2+1])
+		}
+
+		if err := t.mdb.WriteFile(ctx, t.unit, t.file, uint16(record), words[:count]); err != nil {
+			return written, err
+		}
+
+		record += count
+		written += int64(n)
+
+		if n < len(raws) {
+			return written, nil
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFileRecordEncode(t *testing.T) {
+	read := NewRequest(OPCODE_READ_FILE_RECORD)
+	read.AddRecord(FileRecord{File: 4, Record: 1, Length: 2})
+	read.AddRecord(FileRecord{File: 3, Record: 9, Length: 2})
+
+	write := NewRequest(OPCODE_WRITE_FILE_RECORD)
+	write.AddRecord(FileRecord{File: 4, Record: 7, Data: []uint16{0x06AF, 0x04BE, 0x100D}})
+
+	// The expected PDUs are the examples from the Modbus application
+	// protocol specification.
+	tests := []struct {
+		name string
+		req  *Request
+		want []byte
+	}{
+		{"read file record", read, []byte{
+			0x14, 0x0E,
+			0x06, 0x00, 0x04, 0x00, 0x01, 0x00, 0x02,
+			0x06, 0x00, 0x03, 0x00, 0x09, 0x00, 0x02,
+		}},
+		{"write file record", write, []byte{
+			0x15, 0x0D,
+			0x06, 0x00, 0x04, 0x00, 0x07, 0x00, 0x03,
+			0x06, 0xAF, 0x04, 0xBE, 0x10, 0x0D,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkPDU(t, tt.req, tt.want)
+		})
+	}
+}
+
+func TestFileRecordReply(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want []FileRecord
+	}{
+		{"read file record", []byte{
+			0x14, 0x0C,
+			0x05, 0x06, 0x0D, 0xFE, 0x00, 0x20,
+			0x05, 0x06, 0x33, 0xCD, 0x00, 0x40,
+		}, []FileRecord{
+			{Length: 2, Data: []uint16{0x0DFE, 0x0020}},
+			{Length: 2, Data: []uint16{0x33CD, 0x0040}},
+		}},
+		{"write file record", []byte{
+			0x15, 0x0D,
+			0x06, 0x00, 0x04, 0x00, 0x07, 0x00, 0x03,
+			0x06, 0xAF, 0x04, 0xBE, 0x10, 0x0D,
+		}, []FileRecord{
+			{File: 4, Record: 7, Length: 3, Data: []uint16{0x06AF, 0x04BE, 0x100D}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep := &Reply{}
+			if !rep.decode(bufferOf(tt.in).(*_buffer)) {
+				t.Fatal("decode failed")
+			}
+
+			got := rep.Records()
+			if len(got) != len(tt.want) {
+				t.Fatalf("decoded %d records, want %d", len(got), len(tt.want))
+			}
+
+			for i, rec := range tt.want {
+				if got[i].File != rec.File || got[i].Record != rec.Record || got[i].Length != rec.Length {
+					t.Fatalf("record %d = %+v, want %+v", i, got[i], rec)
+				}
+
+				for j, v := range rec.Data {
+					if got[i].Data[j] != v {
+						t.Fatalf("record %d word %d = %04x, want %04x", i, j, got[i].Data[j], v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFileRecordValidate(t *testing.T) {
+	read := func(records ...FileRecord) *Request {
+		req := NewRequest(OPCODE_READ_FILE_RECORD)
+		for _, rec := range records {
+			req.AddRecord(rec)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name string
+		req  *Request
+		err  error
+	}{
+		{"ok", read(FileRecord{File: 1, Record: 0, Length: 10}), nil},
+		{"none", read(), ErrQuantity},
+		{"zero length", read(FileRecord{File: 1, Length: 0}), ErrQuantity},
+		{"file zero", read(FileRecord{File: 0, Length: 1}), ErrAddress},
+		{"past the end", read(FileRecord{File: 1, Record: FILE_RECORD_MAX, Length: 1}), ErrAddress},
+		{"reply too long", read(FileRecord{File: 1, Length: FILE_READ_RECORD_MAX + 1}), ErrQuantity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.validate(); !errors.Is(err, tt.err) {
+				t.Fatalf("validate = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// fileSlave answers file record reads with each word's own record number
+// and echoes file record writes.
+func fileSlave(req []byte) [][]byte {
+	if req[1] == OPCODE_WRITE_FILE_RECORD {
+		return [][]byte{rtuFrame(req[:len(req)-2]...)}
+	}
+
+	body := []byte{}
+	for sub := req[3 : 3+int(req[2])]; len(sub) >= 7; sub = sub[7:] {
+		record := int(sub[3])<<8 | int(sub[4])
+		length := int(sub[5])<<8 | int(sub[6])
+
+		body = append(body, byte(1+length*2), FILE_REFERENCE_TYPE)
+		for i := 0; i < length; i++ {
+			body = append(body, byte((record+i)>>8), byte(record+i))
+		}
+	}
+
+	return [][]byte{rtuFrame(append([]byte{req[0], req[1], byte(len(body))}, body...)...)}
+}
+
+func TestReadWriteFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   uint16
+		length   uint16
+		requests int
+	}{
+		{"one request", 0, 10, 1},
+		{"one full piece", 100, FILE_READ_RECORD_MAX, 1},
+		{"split", 1000, 300, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &memDriver{respond: fileSlave}
+			m := newTestModbus(d)
+
+			data, err := m.ReadFile(context.Background(), 1, 4, tt.record, tt.length)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(data) != int(tt.length) || len(d.sent) != tt.requests {
+				t.Fatalf("read %d words in %d requests", len(data), len(d.sent))
+			}
+
+			for i, v := range data {
+				if v != tt.record+uint16(i) {
+					t.Fatalf("word %d = %d", i, v)
+				}
+			}
+
+			if err := m.WriteFile(context.Background(), 1, 4, tt.record, data); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
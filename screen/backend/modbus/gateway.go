@@ -0,0 +1,236 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+type Table uint8
+
+const (
+	TABLE_COILS Table = iota
+	TABLE_DISCRETE_INPUTS
+	TABLE_HOLDING_REGISTERS
+	TABLE_INPUT_REGISTERS
+)
+
+// Range is an inclusive block of addresses the gateway exposes.
+type Range struct {
+	Table    Table
+	Start    uint16
+	End      uint16
+	ReadOnly bool
+}
+
+func (r Range) contains(address uint16, quantity int) bool {
+	return address >= r.Start && int(address)+quantity-1 <= int(r.End)
+}
+
+// Gateway serves Modbus TCP clients and forwards their requests through
+// mdb, so they share its transaction queue with every other caller. With
+// no ranges allowed every table can be read but nothing written, otherwise
+// only the allowed ranges are exposed.
+type Gateway struct {
+	mdb      *Modbus
+	address  string
+	unit     uint8
+	ranges   []Range
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	exit     context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewGateway(mdb *Modbus, address string, unit uint8) *Gateway {
+	return &Gateway{
+		mdb:     mdb,
+		address: address,
+		unit:    unit,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+func (g *Gateway) Allow(r Range) {
+	g.ranges = append(g.ranges, r)
+}
+
+func (g *Gateway) Addr() net.Addr {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.listener == nil {
+		return nil
+	}
+	return g.listener.Addr()
+}
+
+func (g *Gateway) Start() error {
+	listener, err := net.Listen("tcp", g.address)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	g.mu.Lock()
+	g.listener = listener
+	g.exit = cancel
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go g.accept(ctx, listener)
+	return nil
+}
+
+func (g *Gateway) Stop() error {
+	g.mu.Lock()
+	if g.listener == nil {
+		g.mu.Unlock()
+		return nil
+	}
+
+	g.exit()
+	err := g.listener.Close()
+	for conn := range g.conns {
+		conn.Close()
+	}
+	g.listener = nil
+	g.mu.Unlock()
+
+	g.wg.Wait()
+	return err
+}
+
+func (g *Gateway) accept(ctx context.Context, listener net.Listener) {
+	defer g.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		g.mu.Lock()
+		g.conns[conn] = struct{}{}
+		g.mu.Unlock()
+
+		g.wg.Add(1)
+		go g.serve(ctx, conn)
+	}
+}
+
+func (g *Gateway) serve(ctx context.Context, conn net.Conn) {
+	defer g.wg.Done()
+	defer func() {
+		g.mu.Lock()
+		delete(g.conns, conn)
+		g.mu.Unlock()
+		conn.Close()
+	}()
+
+	srv := NewServer(newConnDriver(conn), NewTCPParser(), 0)
+	srv.SetCoilHandler(g)
+	srv.SetDiscreteInputHandler(g)
+	srv.SetHoldingRegisterHandler(g)
+	srv.SetInputRegisterHandler(g)
+	srv.Serve(ctx)
+}
+
+func (g *Gateway) allowed(table Table, address uint16, quantity int, write bool) error {
+	if len(g.ranges) == 0 {
+		if write {
+			return ErrAddress
+		}
+		return nil
+	}
+
+	for _, r := range g.ranges {
+		if r.Table == table && r.contains(address, quantity) && !(write && r.ReadOnly) {
+			return nil
+		}
+	}
+
+	return ErrAddress
+}
+
+// target maps the TCP unit identifier onto the serial bus, where 0 and 0xFF
+// conventionally mean the gateway itself.
+func (g *Gateway) target(unit uint8) uint8 {
+	if unit == 0 || unit == 0xFF {
+		return g.unit
+	}
+	return unit
+}
+
+func (g *Gateway) ReadCoils(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]bool, error) {
+	if err := g.allowed(TABLE_COILS, address, int(quantity), false); err != nil {
+		return nil, err
+	}
+	return g.mdb.ReadCoils(ctx, g.target(unit), address, quantity)
+}
+
+func (g *Gateway) WriteCoils(ctx context.Context, unit uint8, address uint16, values []bool) error {
+	if err := g.allowed(TABLE_COILS, address, len(values), true); err != nil {
+		return err
+	}
+
+	if len(values) == 1 {
+		return g.mdb.WriteSingleCoil(ctx, g.target(unit), address, values[0])
+	}
+	return g.mdb.WriteMultipleCoils(ctx, g.target(unit), address, values)
+}
+
+func (g *Gateway) ReadDiscreteInputs(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]bool, error) {
+	if err := g.allowed(TABLE_DISCRETE_INPUTS, address, int(quantity), false); err != nil {
+		return nil, err
+	}
+	return g.mdb.ReadDiscreteInputs(ctx, g.target(unit), address, quantity)
+}
+
+func (g *Gateway) ReadHoldingRegisters(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]uint16, error) {
+	if err := g.allowed(TABLE_HOLDING_REGISTERS, address, int(quantity), false); err != nil {
+		return nil, err
+	}
+	return g.mdb.ReadHoldingRegisters(ctx, g.target(unit), address, quantity)
+}
+
+func (g *Gateway) WriteHoldingRegisters(ctx context.Context, unit uint8, address uint16, values []uint16) error {
+	if err := g.allowed(TABLE_HOLDING_REGISTERS, address, len(values), true); err != nil {
+		return err
+	}
+
+	if len(values) == 1 {
+		return g.mdb.WriteSingleRegister(ctx, g.target(unit), address, values[0])
+	}
+	return g.mdb.WriteMultipleRegisters(ctx, g.target(unit), address, values)
+}
+
+func (g *Gateway) MaskWriteRegister(ctx context.Context, unit uint8, address uint16, and uint16, or uint16) error {
+	if err := g.allowed(TABLE_HOLDING_REGISTERS, address, 1, true); err != nil {
+		return err
+	}
+	return g.mdb.MaskWriteRegister(ctx, g.target(unit), address, and, or)
+}
+
+func (g *Gateway) ReadWriteMultipleRegisters(ctx context.Context, unit uint8, readAddress uint16, readQuantity uint16, writeAddress uint16, values []uint16) ([]uint16, error) {
+	if err := g.allowed(TABLE_HOLDING_REGISTERS, writeAddress, len(values), true); err != nil {
+		return nil, err
+	}
+	if err := g.allowed(TABLE_HOLDING_REGISTERS, readAddress, int(readQuantity), false); err != nil {
+		return nil, err
+	}
+	return g.mdb.ReadWriteMultipleRegisters(ctx, g.target(unit), readAddress, readQuantity, writeAddress, values)
+}
+
+func (g *Gateway) ReadInputRegisters(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]uint16, error) {
+	if err := g.allowed(TABLE_INPUT_REGISTERS, address, int(quantity), false); err != nil {
+		return nil, err
+	}
+	return g.mdb.ReadInputRegisters(ctx, g.target(unit), address, quantity)
+}
@@ -0,0 +1,87 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGatewayAllowed(t *testing.T) {
+	ranges := []Range{
+		{Table: TABLE_HOLDING_REGISTERS, Start: 100, End: 109},
+		{Table: TABLE_HOLDING_REGISTERS, Start: 200, End: 200, ReadOnly: true},
+		{Table: TABLE_COILS, Start: 0, End: 7},
+	}
+
+	tests := []struct {
+		name     string
+		ranges   []Range
+		table    Table
+		address  uint16
+		quantity int
+		write    bool
+		err      error
+	}{
+		{"no ranges read", nil, TABLE_HOLDING_REGISTERS, 0, 10, false, nil},
+		{"no ranges write", nil, TABLE_HOLDING_REGISTERS, 0, 1, true, ErrAddress},
+		{"inside", ranges, TABLE_HOLDING_REGISTERS, 100, 10, true, nil},
+		{"last address", ranges, TABLE_HOLDING_REGISTERS, 109, 1, false, nil},
+		{"past the end", ranges, TABLE_HOLDING_REGISTERS, 105, 6, false, ErrAddress},
+		{"before the start", ranges, TABLE_HOLDING_REGISTERS, 99, 2, false, ErrAddress},
+		{"read only read", ranges, TABLE_HOLDING_REGISTERS, 200, 1, false, nil},
+		{"read only write", ranges, TABLE_HOLDING_REGISTERS, 200, 1, true, ErrAddress},
+		{"other table", ranges, TABLE_INPUT_REGISTERS, 100, 1, false, ErrAddress},
+		{"coils", ranges, TABLE_COILS, 0, 8, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGateway(nil, "", 1)
+			for _, r := range tt.ranges {
+				g.Allow(r)
+			}
+
+			if err := g.allowed(tt.table, tt.address, tt.quantity, tt.write); !errors.Is(err, tt.err) {
+				t.Fatalf("allowed = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestGatewayForward(t *testing.T) {
+	bus := &memDriver{respond: registerSlave}
+	g := NewGateway(newTestModbus(bus), "127.0.0.1:0", 1)
+	g.Allow(Range{Table: TABLE_HOLDING_REGISTERS, Start: 10, End: 19, ReadOnly: true})
+
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop()
+
+	client := New(NewTCPDriver(g.Addr().String()), NewTCPParser())
+	client.SetTimeout(time.Second)
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	regs, err := client.ReadHoldingRegisters(ctx, 0, 10, 2)
+	if err != nil || regs[0] != 10 || regs[1] != 11 {
+		t.Fatalf("read = %v %v", regs, err)
+	}
+
+	var exc *ExceptionError
+	if _, err := client.ReadHoldingRegisters(ctx, 0, 20, 1); !errors.As(err, &exc) || exc.Code != EXCEPTION_ILLEGAL_DATA_ADDRESS {
+		t.Fatalf("read outside the range = %v", err)
+	}
+
+	if err := client.WriteSingleRegister(ctx, 0, 10, 1); !errors.As(err, &exc) || exc.Code != EXCEPTION_ILLEGAL_DATA_ADDRESS {
+		t.Fatalf("write to a read only range = %v", err)
+	}
+
+	if n := len(sentFrames(bus)); n != 1 {
+		t.Fatalf("forwarded %d requests to the bus, want 1", n)
+	}
+}
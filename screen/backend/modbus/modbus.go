@@ -1,32 +1,103 @@
 package modbus
 
 import (
-	"fmt"
+	"context"
 	"time"
 )
 
+const (
+	FRAME_MAX       = 513
+	POLL_INTERVAL   = 100 * time.Millisecond
+	BROADCAST       = uint8(0)
+	BROADCAST_DELAY = 100 * time.Millisecond
+)
+
 type Modbus struct {
-	driver Driver
-	parser Parser
+	driver  Driver
+	parser  Parser
+	timeout time.Duration
+	retry   RetryPolicy
+	t15     time.Duration
+	t35     time.Duration
+	idle    time.Time
+	queue   _queue
+	stats   _stats
+	frame   _buffer
+	addr    uint8
+	stale   bool
 }
 
 func New(driver Driver, parser Parser) *Modbus {
 
 	return &Modbus{
-		driver: driver,
-		parser: parser,
+		driver:  driver,
+		parser:  parser,
+		timeout: 1 * time.Second,
+		retry:   DefaultRetryPolicy,
+		frame: _buffer{
+			capacity: FRAME_MAX,
+			flag:     BUFFER_EMPTY,
+			raws:     make([]uint8, FRAME_MAX),
+		},
 	}
 }
 
+func silence(driver Driver, parser Parser) (time.Duration, time.Duration) {
+	drv, ok := driver.(baudDriver)
+	prs, pok := parser.(silenceParser)
+	if !ok || !pok {
+		return 0, 0
+	}
+
+	t15, t35 := prs.silence(drv.BaudRate())
+	fdrv, ok := driver.(floorDriver)
+	if !ok {
+		return t15, t35
+	}
+
+	floor := fdrv.SilenceFloor()
+	if t15 > 0 && t15 < floor {
+		t15 = floor
+	}
+	if t35 > 0 && t35 < floor {
+		t35 = floor
+	}
+	return t15, t35
+}
+
+// broadcast reports whether unit addresses every slave on a serial bus.
+// Over TCP unit 0 is just another unit identifier.
+func (m *Modbus) broadcast(unit uint8) bool {
+	_, tcp := m.parser.(*_tcpParser)
+	return unit == BROADCAST && !tcp
+}
+
+func (m *Modbus) SetTimeout(t time.Duration) {
+	m.timeout = t
+}
+
+func (m *Modbus) SetRetryPolicy(p RetryPolicy) {
+	m.retry = p
+}
+
 func (m *Modbus) Open() error {
+	m.queue.acquire(context.Background(), PriorityHigh)
+	defer m.queue.release()
+
 	if err := m.driver.Open(); err != nil {
 		return err
 	}
 
+	m.t15, m.t35 = silence(m.driver, m.parser)
+
+	m.idle = time.Now()
 	return nil
 }
 
 func (m *Modbus) Close() error {
+	m.queue.acquire(context.Background(), PriorityHigh)
+	defer m.queue.release()
+
 	if err := m.driver.Close(); err != nil {
 		return err
 	}
@@ -34,35 +105,255 @@ func (m *Modbus) Close() error {
 	return nil
 }
 
-func (m *Modbus) Exec(addr uint8, req *Request) *Reply {
-	byteLen := 256
-	bytes := make([]byte, byteLen)
+func (m *Modbus) Exec(addr uint8, req *Request) (*Reply, error) {
+	return m.ExecContext(context.Background(), addr, req)
+}
 
-	writer := NewBufferWriter(bytes, byteLen)
-	if !m.parser.Encode(&addr, req, writer) {
-		return nil
+// ExecContext returns a nil reply for a broadcast, which no slave answers.
+func (m *Modbus) ExecContext(ctx context.Context, addr uint8, req *Request) (*Reply, error) {
+	rep := &Reply{}
+	if err := m.Transact(ctx, addr, req, rep); err != nil {
+		return nil, err
 	}
-	writer.WriteTo(m.driver)
 
-	time.Sleep(20 * time.Microsecond)
+	if m.broadcast(addr) {
+		return nil, nil
+	}
 
-	reader := NewBufferWith(bytes, byteLen)
-	reader.ReadFrom(m.driver)
+	return rep, nil
+}
 
-	rep := &Reply{}
-	if !m.parser.Decode(&addr, rep, reader) {
+// Transact runs req and decodes the answer into rep. Request and Reply can
+// be reused across calls, which keeps a polling loop free of allocations;
+// the payload of rep is only valid until its next use.
+func (m *Modbus) Transact(ctx context.Context, addr uint8, req *Request, rep *Reply) error {
+	if err := req.validate(); err != nil {
+		return err
+	}
+
+	if m.broadcast(addr) && !opcodeBroadcast(req.opcode) {
+		return ErrBroadcast
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := m.exec(ctx, addr, req, rep)
+		if err == nil || attempt >= m.retry.Attempts || !m.retry.retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.retry.backoff(attempt)):
+		}
+	}
+}
+
+type heldKey struct{}
+
+// hold takes the queue for a transfer split over several requests, so that
+// no other traffic lands between its chunks. Requests made with the returned
+// context skip the queue until release is called.
+func (m *Modbus) hold(ctx context.Context) (context.Context, func(), error) {
+	if ctx.Value(heldKey{}) == m {
+		return ctx, func() {}, nil
+	}
+
+	if err := m.queue.acquire(ctx, priorityFrom(ctx)); err != nil {
+		return nil, nil, err
+	}
+
+	return context.WithValue(ctx, heldKey{}, m), m.queue.release, nil
+}
+
+func (m *Modbus) exec(ctx context.Context, unit uint8, req *Request, rep *Reply) error {
+	if ctx.Value(heldKey{}) != m {
+		if err := m.queue.acquire(ctx, priorityFrom(ctx)); err != nil {
+			return err
+		}
+		defer m.queue.release()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := m.drain(); err != nil {
+		return err
+	}
+
+	m.addr = unit
+	m.frame.reset()
+	if err := m.parser.Encode(&m.addr, req, &m.frame); err != nil {
+		return err
+	}
+
+	if wait := m.t35 - time.Since(m.idle); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	if _, err := m.frame.WriteTo(m.driver); err != nil {
+		return err
+	}
+	m.idle = time.Now()
+	sent := m.idle
+
+	if m.broadcast(unit) {
+		m.stats.sent(unit)
+		// Give every slave time to act on it before the next request.
+		m.idle = m.idle.Add(BROADCAST_DELAY)
 		return nil
 	}
-	return rep
+
+	tally := _tally{}
+	m.frame.reset()
+	err := m.reply(ctx, unit, req, rep, &tally)
+	m.stale = err != nil && !isException(err)
+	m.stats.record(unit, &tally, time.Since(sent), err)
+	return err
 }
 
-func (m *Modbus) Test() {
-	req := NewRequest(OPCODE_READ_HOLDING_REGISTERS)
-	req.Address = 0
-	req.SetLength(2)
+// drain throws away input nobody asked for before the next request goes
+// out. After a failed transaction the slave may still be answering it, so
+// the line has to stay quiet for t3.5 before that late reply is ruled out.
+// TCP needs none of this, its transaction ids already tell replies apart.
+func (m *Modbus) drain() error {
+	drv, ok := m.driver.(timeoutDriver)
+	if _, tcp := m.parser.(*_tcpParser); !ok || tcp {
+		return nil
+	}
+
+	quiet := time.Duration(0)
+	if m.stale {
+		quiet = m.t35
+		if quiet == 0 {
+			// Without a baud rate there is no t3.5 to go by.
+			quiet = POLL_INTERVAL
+		}
+	}
+	drv.SetReadTimeout(quiet)
+
+	for {
+		m.frame.reset()
+		n, err := m.frame.ReadFrom(m.driver)
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			break
+		}
+		m.idle = time.Now()
+	}
+
+	m.frame.reset()
+	m.stale = false
+	return nil
+}
+
+func (m *Modbus) reply(ctx context.Context, unit uint8, req *Request, rep *Reply, tally *_tally) error {
+	deadline := m.idle.Add(m.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	if err := m.receive(ctx, &m.addr, rep, &m.frame, deadline, tally); err != nil {
+		return err
+	}
+
+	if err := rep.Exception(); err != nil {
+		return err
+	}
+
+	if m.addr != unit || rep.opcode != req.opcode || !rep.matches(req) {
+		return ErrUnexpected
+	}
+
+	return nil
+}
+
+// receive keeps a partial frame until the parser has all the bytes its
+// function code and byte count call for, or the deadline passes. Silence on
+// the line only delimits frames: after t3.5 of it the buffer is searched for
+// a complete reply behind leading noise, without dropping anything.
+func (m *Modbus) receive(ctx context.Context, addr *uint8, rep *Reply, b Buffer, deadline time.Time, tally *_tally) error {
+	var last error = ErrTimeout
+	scanned := false
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return last
+		}
+
+		if wait > POLL_INTERVAL {
+			wait = POLL_INTERVAL
+		}
+
+		if !b.IsEmpty() && m.t15 > 0 && wait > m.t15 {
+			wait = m.t15
+		}
+
+		if drv, ok := m.driver.(timeoutDriver); ok {
+			drv.SetReadTimeout(wait)
+		}
+
+		n, err := b.ReadFrom(m.driver)
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			if !scanned && !b.IsEmpty() && m.t35 > 0 && time.Since(m.idle) >= m.t35 {
+				scanned = true
+				if err := m.resync(addr, rep, b, tally); err != ErrShortFrame {
+					return err
+				}
+			}
+			continue
+		}
+		m.idle = time.Now()
+		scanned = false
+
+		before := b.Length()
+		err = m.parser.Decode(addr, rep, b)
+		if err == ErrChecksum {
+			tally.checksums++
+		}
+
+		for err == ErrChecksum {
+			last = err
+			tally.skipped += uint64(before - b.Length())
+			before = b.Length()
+			err = m.parser.Decode(addr, rep, b)
+		}
+
+		if err != ErrShortFrame {
+			return err
+		}
+	}
+}
+
+// resync looks for a complete frame at every later offset of b, for when
+// the bytes in front only look like the start of a longer one. b is left
+// alone unless a frame is found.
+func (m *Modbus) resync(addr *uint8, rep *Reply, b Buffer, tally *_tally) error {
+	for skip := 1; skip < b.Length(); skip++ {
+		frame := b.Clone()
+		frame.Skip(skip)
+
+		if m.parser.Decode(addr, rep, frame) != nil {
+			continue
+		}
+
+		tally.skipped += uint64(skip)
+		b.Skip(b.Length() - frame.Length())
+		return nil
+	}
 
-	rep := m.Exec(1, req)
-	pyd := rep.Payload().(PayloadU16)
-	fmt.Printf("%v\n", pyd.IsU16())
-	fmt.Printf("%v\n", pyd.Get(0))
+	return ErrShortFrame
 }
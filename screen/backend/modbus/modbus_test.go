@@ -0,0 +1,390 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// usbLatency is the floor a USB serial adapter asks for.
+const usbLatency = 20 * time.Millisecond
+
+// chunk is a piece of a reply the memDriver hands out once its time has come.
+type chunk struct {
+	at   time.Time
+	data []byte
+}
+
+// memDriver is an in-memory serial line. respond turns every written frame
+// into the chunks the slave answers with, each delayed by gap after the one
+// before it. A floor makes it pass for a USB adapter.
+type memDriver struct {
+	mu      sync.Mutex
+	baud    int
+	timeout time.Duration
+	gap     time.Duration
+	sent    [][]byte
+	pending []chunk
+	respond func(req []byte) [][]byte
+	floor   time.Duration
+}
+
+func (d *memDriver) Open() error  { return nil }
+func (d *memDriver) Close() error { return nil }
+
+func (d *memDriver) BaudRate() int { return d.baud }
+
+func (d *memDriver) SilenceFloor() time.Duration { return d.floor }
+
+func (d *memDriver) SetReadTimeout(t time.Duration) error {
+	d.timeout = t
+	return nil
+}
+
+// queue schedules raws as the next chunks of input.
+func (d *memDriver) queue(raws ...[]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	at := time.Now()
+	if n := len(d.pending); n > 0 && d.pending[n-1].at.After(at) {
+		at = d.pending[n-1].at
+	}
+
+	for _, r := range raws {
+		at = at.Add(d.gap)
+		d.pending = append(d.pending, chunk{at: at, data: r})
+	}
+}
+
+func (d *memDriver) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	d.sent = append(d.sent, append([]byte(nil), p...))
+	respond := d.respond
+	d.mu.Unlock()
+
+	if respond != nil {
+		d.queue(respond(p)...)
+	}
+	return len(p), nil
+}
+
+func (d *memDriver) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	if len(d.pending) == 0 {
+		d.mu.Unlock()
+		time.Sleep(d.timeout)
+		return 0, nil
+	}
+
+	next := d.pending[0]
+	if wait := time.Until(next.at); wait > 0 {
+		d.mu.Unlock()
+		if wait > d.timeout {
+			time.Sleep(d.timeout)
+			return 0, nil
+		}
+		time.Sleep(wait)
+		d.mu.Lock()
+	}
+
+	n := copy(p, next.data)
+	if n < len(next.data) {
+		d.pending[0].data = next.data[n:]
+	} else {
+		d.pending = d.pending[1:]
+	}
+	d.mu.Unlock()
+	return n, nil
+}
+
+// reply answers every request with the same frame.
+func reply(raws ...[]byte) func(req []byte) [][]byte {
+	return func(req []byte) [][]byte {
+		return raws
+	}
+}
+
+func newTestModbus(d *memDriver) *Modbus {
+	if d.timeout == 0 {
+		d.timeout = POLL_INTERVAL
+	}
+
+	m := New(d, NewRTUParser())
+	m.SetTimeout(100 * time.Millisecond)
+	m.SetRetryPolicy(RetryPolicy{Attempts: 1})
+	m.Open()
+	return m
+}
+
+func TestSilence(t *testing.T) {
+	char := 11 * time.Second / 9600
+
+	tests := []struct {
+		name     string
+		baud     int
+		floor    time.Duration
+		t15, t35 time.Duration
+	}{
+		{"fast", 115200, 0, 750 * time.Microsecond, 1750 * time.Microsecond},
+		{"fast usb", 115200, usbLatency, usbLatency, usbLatency},
+		{"slow", 9600, 0, char * 3 / 2, char * 7 / 2},
+		{"slow low floor", 9600, time.Millisecond, char * 3 / 2, char * 7 / 2},
+		{"slow between", 9600, 3 * time.Millisecond, 3 * time.Millisecond, char * 7 / 2},
+		{"no baud", 0, usbLatency, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModbus(&memDriver{baud: tt.baud, floor: tt.floor})
+			if m.t15 != tt.t15 || m.t35 != tt.t35 {
+				t.Fatalf("t1.5 %v t3.5 %v, want %v and %v", m.t15, m.t35, tt.t15, tt.t35)
+			}
+		})
+	}
+}
+
+func TestReceiveFragments(t *testing.T) {
+	frame := rtuFrame(0x01, 0x03, 0x04, 0x00, 0x05, 0x00, 0x06)
+
+	tests := []struct {
+		name   string
+		gap    time.Duration
+		chunks [][]byte
+	}{
+		{"whole", 0, [][]byte{frame}},
+		{"usb packets", 8 * time.Millisecond, [][]byte{frame[:3], frame[3:5], frame[5:]}},
+		{"slow adapter", 2 * usbLatency, [][]byte{frame[:1], frame[1:6], frame[6:]}},
+		{"noise before", 5 * time.Millisecond, [][]byte{{0x01, 0x03, 0x7F}, frame[:4], frame[4:]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &memDriver{baud: 115200, gap: tt.gap, floor: usbLatency, respond: reply(tt.chunks...)}
+			m := newTestModbus(d)
+			m.SetTimeout(time.Second)
+
+			regs, err := m.ReadHoldingRegisters(context.Background(), 1, 0, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if regs[0] != 5 || regs[1] != 6 {
+				t.Fatalf("registers = %v", regs)
+			}
+		})
+	}
+}
+
+func TestStaleReplyDrained(t *testing.T) {
+	d := &memDriver{}
+	calls := 0
+	d.respond = func(req []byte) [][]byte {
+		calls++
+		// The first answer is late enough to miss its deadline but lands
+		// while the retry is being prepared.
+		d.gap = 0
+		if calls == 1 {
+			d.gap = 70 * time.Millisecond
+		}
+		return [][]byte{rtuFrame(0x01, 0x03, 0x02, 0x00, byte(calls))}
+	}
+
+	m := newTestModbus(d)
+	m.SetTimeout(50 * time.Millisecond)
+	m.SetRetryPolicy(RetryPolicy{Attempts: 2, Backoff: time.Millisecond})
+
+	regs, err := m.ReadHoldingRegisters(context.Background(), 1, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if regs[0] != 2 || calls != 2 {
+		t.Fatalf("got value %d after %d requests, want the reply to the retry", regs[0], calls)
+	}
+}
+
+// loopDriver answers every write with the same frame at once, without
+// timeouts or bookkeeping, so only the cost of the Modbus code is measured.
+type loopDriver struct {
+	answer  []byte
+	pending bool
+}
+
+func (d *loopDriver) Open() error  { return nil }
+func (d *loopDriver) Close() error { return nil }
+
+func (d *loopDriver) Write(p []byte) (int, error) {
+	d.pending = true
+	return len(p), nil
+}
+
+func (d *loopDriver) Read(p []byte) (int, error) {
+	if !d.pending {
+		return 0, nil
+	}
+
+	d.pending = false
+	return copy(p, d.answer), nil
+}
+
+func newLoopModbus() (*Modbus, *Request, *Reply) {
+	m := New(&loopDriver{answer: rtuFrame(0x01, 0x03, 0x04, 0x00, 0x05, 0x00, 0x06)}, NewRTUParser())
+	m.Open()
+	return m, readRequest(0, 2), &Reply{}
+}
+
+func TestTransactAllocs(t *testing.T) {
+	m, req, rep := newLoopModbus()
+	ctx := context.Background()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := m.Transact(ctx, 1, req, rep); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if allocs != 0 {
+		t.Fatalf("Transact allocates %v times per call", allocs)
+	}
+}
+
+func BenchmarkExec(b *testing.B) {
+	m, req, rep := newLoopModbus()
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := m.Transact(ctx, 1, req, rep); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeDecode(b *testing.B) {
+	parsers := map[string]func() Parser{
+		"rtu":   NewRTUParser,
+		"ascii": NewASCIIParser,
+		"tcp":   NewTCPParser,
+	}
+
+	for name, parser := range parsers {
+		b.Run(name, func(b *testing.B) {
+			p := parser()
+			buf := NewBuffer(FRAME_MAX * 2)
+			req := readRequest(0, 2)
+			got := &Request{}
+			unit := uint8(1)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := p.Encode(&unit, req, buf); err != nil {
+					b.Fatal(err)
+				}
+
+				if err := p.DecodeRequest(&unit, got, buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCRCTable(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for n := 1; n <= FRAME_MAX; n++ {
+		raws := make([]byte, n)
+		rnd.Read(raws)
+
+		b := NewBuffer(FRAME_MAX).(*_buffer)
+		// Start part way in so the frame wraps around the ring.
+		b.readpos, b.writpos = FRAME_MAX/2, FRAME_MAX/2
+		b.Write(raws)
+
+		if got, want := rtu_crc16(b, n), crc16Bitwise(raws); got != want {
+			t.Fatalf("crc of %d bytes = %04x, want %04x", n, got, want)
+		}
+
+		if b.Length() != n {
+			t.Fatalf("rtu_crc16 left %d of %d bytes", b.Length(), n)
+		}
+	}
+}
+
+// foreignBuffer is a Buffer that is not this package's own, which sends the
+// parsers down their slow path.
+type foreignBuffer struct {
+	Buffer
+}
+
+func TestForeignBuffer(t *testing.T) {
+	parsers := map[string]func() Parser{
+		"rtu":   NewRTUParser,
+		"ascii": NewASCIIParser,
+		"tcp":   NewTCPParser,
+	}
+
+	for name, parser := range parsers {
+		t.Run(name, func(t *testing.T) {
+			p := parser()
+			unit := uint8(7)
+
+			native := NewBuffer(FRAME_MAX * 2)
+			if err := p.Encode(&unit, readRequest(0x0102, 3), native); err != nil {
+				t.Fatal(err)
+			}
+
+			p = parser()
+			foreign := &foreignBuffer{NewBuffer(FRAME_MAX * 2)}
+			if err := p.Encode(&unit, readRequest(0x0102, 3), foreign); err != nil {
+				t.Fatal(err)
+			}
+
+			want := bytesOf(native.Clone())
+			if got := bytesOf(foreign.Clone()); !bytes.Equal(got, want) {
+				t.Fatalf("encode = % x, want % x", got, want)
+			}
+
+			// A partial frame is left alone.
+			partial := &foreignBuffer{bufferOf(want[:len(want)-1])}
+			req := &Request{}
+			if err := p.DecodeRequest(&unit, req, partial); !errors.Is(err, ErrShortFrame) || partial.Length() != len(want)-1 {
+				t.Fatalf("decode = %v with %d bytes left", err, partial.Length())
+			}
+
+			unit = 0
+			if err := p.DecodeRequest(&unit, req, foreign); err != nil {
+				t.Fatal(err)
+			}
+
+			if unit != 7 || req.Address != 0x0102 || req.lenOrVal != 3 || !foreign.IsEmpty() {
+				t.Fatalf("decoded unit %d request %+v, %d bytes left", unit, req, foreign.Length())
+			}
+		})
+	}
+}
+
+func TestForeignBufferFull(t *testing.T) {
+	unit := uint8(1)
+	small := &foreignBuffer{NewBuffer(4)}
+
+	if err := NewRTUParser().Encode(&unit, readRequest(0, 1), small); !errors.Is(err, ErrEncode) || !small.IsEmpty() {
+		t.Fatalf("encode into 4 bytes = %v with %d bytes written", err, small.Length())
+	}
+}
+
+func TestForeignBufferChecksum(t *testing.T) {
+	frame := rtuFrame(0x01, 0x03, 0x00, 0x00, 0x00, 0x01)
+	frame[2] ^= 0xFF
+
+	b := &foreignBuffer{bufferOf(frame)}
+	unit := uint8(0)
+	if err := NewRTUParser().DecodeRequest(&unit, &Request{}, b); !errors.Is(err, ErrChecksum) || b.Length() != len(frame)-1 {
+		t.Fatalf("decode = %v with %d bytes left, want one byte skipped", err, b.Length())
+	}
+}
@@ -7,12 +7,67 @@ const (
 	OPCODE_READ_INPUT_REGISTERS   = 0x04
 	OPCODE_WRITE_COIL             = 0x05
 	OPCODE_WRITE_REGISTER         = 0x06
+	OPCODE_DIAGNOSTICS            = 0x08
 	OPCODE_WRITE_COILS            = 0x0F
 	OPCODE_WRITE_REGISTERS        = 0x10
+	OPCODE_READ_FILE_RECORD       = 0x14
+	OPCODE_WRITE_FILE_RECORD      = 0x15
+	OPCODE_MASK_WRITE_REGISTER    = 0x16
+	OPCODE_READ_WRITE_REGISTERS   = 0x17
+	OPCODE_ENCAPSULATED_INTERFACE = 0x2B
 	OPCODE_ERROR_MASK             = 0x80
 	OPCODE_FUNC_MASK              = 0x7F
 )
 
+const (
+	MEI_READ_DEVICE_ID = 0x0E
+
+	DEVICE_ID_BASIC      = 0x01
+	DEVICE_ID_REGULAR    = 0x02
+	DEVICE_ID_EXTENDED   = 0x03
+	DEVICE_ID_INDIVIDUAL = 0x04
+
+	OBJECT_VENDOR_NAME      = 0x00
+	OBJECT_PRODUCT_CODE     = 0x01
+	OBJECT_REVISION         = 0x02
+	OBJECT_VENDOR_URL       = 0x03
+	OBJECT_PRODUCT_NAME     = 0x04
+	OBJECT_MODEL_NAME       = 0x05
+	OBJECT_USER_APPLICATION = 0x06
+	OBJECT_SERIAL_NUMBER    = 0x80
+	OBJECT_MAC_ADDRESS      = 0x81
+)
+
+const (
+	DIAG_RETURN_QUERY_DATA          = 0x0000
+	DIAG_RESTART_COMMUNICATIONS     = 0x0001
+	DIAG_RETURN_DIAGNOSTIC_REGISTER = 0x0002
+	DIAG_FORCE_LISTEN_ONLY          = 0x0004
+	DIAG_CLEAR_COUNTERS             = 0x000A
+	DIAG_BUS_MESSAGE_COUNT          = 0x000B
+	DIAG_BUS_COMM_ERROR_COUNT       = 0x000C
+	DIAG_BUS_EXCEPTION_ERROR_COUNT  = 0x000D
+	DIAG_SLAVE_MESSAGE_COUNT        = 0x000E
+	DIAG_SLAVE_NO_RESPONSE_COUNT    = 0x000F
+	DIAG_SLAVE_NAK_COUNT            = 0x0010
+	DIAG_SLAVE_BUSY_COUNT           = 0x0011
+	DIAG_BUS_CHARACTER_OVERRUN      = 0x0012
+	DIAG_CLEAR_OVERRUN              = 0x0014
+)
+
+const (
+	ADDRESS_MAX              = 0x10000
+	READ_BITS_MAX            = 2000
+	READ_REGISTERS_MAX       = 125
+	WRITE_BITS_MAX           = 1968
+	WRITE_REGISTERS_MAX      = 123
+	READ_WRITE_REGISTERS_MAX = 121
+	FILE_REFERENCE_TYPE      = 0x06
+	FILE_RECORD_MAX          = 10000
+	FILE_REQUEST_MAX         = 245
+	FILE_DATA_MAX            = 251
+)
+
 func opcodeHasErr(opcode uint8) bool {
 	return (opcode & OPCODE_ERROR_MASK) == OPCODE_ERROR_MASK
 }
@@ -29,8 +84,14 @@ func opcodeAllowed(opcode uint8) bool {
 		(mopcode == OPCODE_READ_INPUT_REGISTERS) ||
 		(mopcode == OPCODE_WRITE_COIL) ||
 		(mopcode == OPCODE_WRITE_REGISTER) ||
+		(mopcode == OPCODE_DIAGNOSTICS) ||
 		(mopcode == OPCODE_WRITE_COILS) ||
 		(mopcode == OPCODE_WRITE_REGISTERS) ||
+		(mopcode == OPCODE_READ_FILE_RECORD) ||
+		(mopcode == OPCODE_WRITE_FILE_RECORD) ||
+		(mopcode == OPCODE_MASK_WRITE_REGISTER) ||
+		(mopcode == OPCODE_READ_WRITE_REGISTERS) ||
+		(mopcode == OPCODE_ENCAPSULATED_INTERFACE) ||
 		(opcodeHasErr(opcode))
 }
 
@@ -39,7 +100,8 @@ func opcodeRequestPayloadBit(opcode uint8) bool {
 }
 
 func opcodeRequestPayloadU16(opcode uint8) bool {
-	return (opcode == OPCODE_WRITE_REGISTERS)
+	return (opcode == OPCODE_WRITE_REGISTERS) ||
+		(opcode == OPCODE_READ_WRITE_REGISTERS)
 }
 
 func opcodeRequestHaspayload(opcode uint8) bool {
@@ -48,13 +110,13 @@ func opcodeRequestHaspayload(opcode uint8) bool {
 
 func opcodeReplyPayloadBit(opcode uint8) bool {
 	return (opcode == OPCODE_READ_COILS) ||
-		(opcode == OPCODE_DISCRETE_INPUTS) ||
-		(opcodeHasErr(opcode))
+		(opcode == OPCODE_DISCRETE_INPUTS)
 }
 
 func opcodeReplyPayloadU16(opcode uint8) bool {
 	return (opcode == OPCODE_READ_HOLDING_REGISTERS) ||
-		(opcode == OPCODE_READ_INPUT_REGISTERS)
+		(opcode == OPCODE_READ_INPUT_REGISTERS) ||
+		(opcode == OPCODE_READ_WRITE_REGISTERS)
 }
 
 func opcodeReplyHasPayload(opcode uint8) bool {
@@ -64,6 +126,31 @@ func opcodeReplyHasPayload(opcode uint8) bool {
 func opcodeReplyHasAttr(opcode uint8) bool {
 	return (opcode == OPCODE_WRITE_COIL) ||
 		(opcode == OPCODE_WRITE_REGISTER) ||
+		(opcode == OPCODE_DIAGNOSTICS) ||
 		(opcode == OPCODE_WRITE_COILS) ||
 		(opcode == OPCODE_WRITE_REGISTERS)
 }
+
+func opcodeBroadcast(opcode uint8) bool {
+	return (opcode == OPCODE_WRITE_COIL) ||
+		(opcode == OPCODE_WRITE_REGISTER) ||
+		(opcode == OPCODE_WRITE_COILS) ||
+		(opcode == OPCODE_WRITE_REGISTERS) ||
+		(opcode == OPCODE_WRITE_FILE_RECORD) ||
+		(opcode == OPCODE_MASK_WRITE_REGISTER)
+}
+
+func opcodeQuantityMax(opcode uint8) int {
+	switch opcode {
+	case OPCODE_READ_COILS, OPCODE_DISCRETE_INPUTS:
+		return READ_BITS_MAX
+	case OPCODE_READ_HOLDING_REGISTERS, OPCODE_READ_INPUT_REGISTERS, OPCODE_READ_WRITE_REGISTERS:
+		return READ_REGISTERS_MAX
+	case OPCODE_WRITE_COILS:
+		return WRITE_BITS_MAX
+	case OPCODE_WRITE_REGISTERS:
+		return WRITE_REGISTERS_MAX
+	}
+
+	return 0
+}
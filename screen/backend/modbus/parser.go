@@ -1,124 +1,219 @@
 package modbus
 
+import "time"
+
+// Decode returns ErrShortFrame while the frame is incomplete and ErrChecksum
+// after dropping one byte of a corrupt frame, so it can be called again. A
+// decoded frame is consumed from b.
 type Parser interface {
-	Encode(addr *uint8, req *Request, b Buffer) bool
-	Decode(addr *uint8, rep *Reply, b Buffer) bool
+	Encode(addr *uint8, req *Request, b Buffer) error
+	Decode(addr *uint8, rep *Reply, b Buffer) error
+
+	EncodeReply(addr *uint8, rep *Reply, b Buffer) error
+	DecodeRequest(addr *uint8, req *Request, b Buffer) error
 }
 
 type _rtuParser struct {
 }
 
-func rtu_crc16(b Buffer, max int) uint16 {
-	table := []uint16{0x0000, 0xA001}
+// _pdu encodes or decodes whichever of req and rep is set. The parsers call
+// it instead of a method value so the hot path stays free of allocations.
+type _pdu struct {
+	req *Request
+	rep *Reply
+}
+
+func (p _pdu) encode(b *_buffer) bool {
+	if p.req != nil {
+		return p.req.encode(b)
+	}
+	return p.rep.encode(b)
+}
+
+func (p _pdu) decode(b *_buffer) bool {
+	if p.req != nil {
+		return p.req.decode(b)
+	}
+	return p.rep.decode(b)
+}
+
+type silenceParser interface {
+	silence(baud int) (time.Duration, time.Duration)
+}
+
+// functionParser is implemented by parsers that cannot tell where a request
+// with an unknown function code ends. Such a frame is only complete once the
+// line has gone quiet, and is then checked as a whole by decodeFunction.
+type functionParser interface {
+	unknownFunction(b Buffer) bool
+	decodeFunction(addr *uint8, opcode *uint8, b Buffer) error
+}
+
+var rtu_table = func() [256]uint16 {
+	table := [256]uint16{}
+	for i := range table {
+		crc := uint16(i)
+		for bit := 0; bit < 8; bit++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// rtu_crc16 checksums up to max bytes from the read position of b without
+// consuming them.
+func rtu_crc16(b *_buffer, max int) uint16 {
 	crc := uint16(0xFFFF)
-	bit := uint8(0x00)
-	xor := uint32(0x00000000)
 	len := b.Length()
-	val := uint8(0)
 
 	if len > max {
 		len = max
 	}
 
-	for len > 0 {
-		b.ReadU8(&val)
+	for i := 0; i < len; i++ {
+		crc = (crc >> 8) ^ rtu_table[uint8(crc)^b.peek(i)]
+	}
 
-		crc ^= uint16(val)
-		for bit = 0; bit < 8; bit++ {
-			xor = uint32(crc) & 1
-			crc >>= 1
-			crc ^= table[xor]
-		}
+	return crc
+}
 
-		len -= 1
+func rtu_silence(baud int) (time.Duration, time.Duration) {
+	if baud <= 0 {
+		return 0, 0
 	}
 
-	return crc
+	if baud > 19200 {
+		return 750 * time.Microsecond, 1750 * time.Microsecond
+	}
+
+	char := time.Duration(11) * time.Second / time.Duration(baud)
+	return char * 3 / 2, char * 7 / 2
 }
 
 func NewRTUParser() Parser {
 	return &_rtuParser{}
 }
 
-func (p *_rtuParser) Encode(addr *uint8, req *Request, b Buffer) bool {
+func (p *_rtuParser) silence(baud int) (time.Duration, time.Duration) {
+	return rtu_silence(baud)
+}
 
-	b.WriteU8(*addr)
-	b.WriteU8(req.opcode)
-	b.WriteU16(req.Address, true)
-	b.WriteU16(req.lenOrVal, true)
+func (p *_rtuParser) Encode(addr *uint8, req *Request, b Buffer) error {
+	return p.encode(addr, _pdu{req: req}, b)
+}
 
-	if opcodeRequestHaspayload(req.opcode) {
-		if !req.payload.WriteTo(b) {
-			return false
-		}
+func (p *_rtuParser) Decode(addr *uint8, rep *Reply, b Buffer) error {
+	return p.decode(addr, _pdu{rep: rep}, b)
+}
+
+func (p *_rtuParser) EncodeReply(addr *uint8, rep *Reply, b Buffer) error {
+	return p.encode(addr, _pdu{rep: rep}, b)
+}
+
+func (p *_rtuParser) DecodeRequest(addr *uint8, req *Request, b Buffer) error {
+	return p.decode(addr, _pdu{req: req}, b)
+}
+
+func (p *_rtuParser) encode(addr *uint8, pdu _pdu, b Buffer) error {
+	buf, ok := b.(*_buffer)
+	if !ok {
+		return encodeSlow(b, func(buf *_buffer) error {
+			return p.encode(addr, pdu, buf)
+		})
 	}
 
-	crc_reader := b.Clone()
-	crc16 := rtu_crc16(crc_reader, crc_reader.Length())
+	start := buf.Length()
+	buf.WriteU8(*addr)
+
+	if !pdu.encode(buf) {
+		return ErrEncode
+	}
 
-	return b.WriteU16(crc16, false)
+	frame := *buf
+	frame.Skip(start)
+	crc16 := rtu_crc16(&frame, frame.Length())
+
+	if !buf.WriteU16(crc16, false) {
+		return ErrEncode
+	}
+
+	return nil
 }
 
-func (p *_rtuParser) Decode(addr *uint8, rep *Reply, b Buffer) bool {
-	for {
-		reader := b.Clone()
-		crc_reader := reader.Clone()
+func (p *_rtuParser) decode(addr *uint8, pdu _pdu, b Buffer) error {
+	buf, ok := b.(*_buffer)
+	if !ok {
+		return decodeSlow(b, func(buf *_buffer) error {
+			return p.decode(addr, pdu, buf)
+		})
+	}
 
-		if !reader.ReadU8(addr) {
-			return false
-		}
+	reader := *buf
 
-		if !reader.ReadU8(&rep.opcode) {
-			return false
-		}
+	if !reader.ReadU8(addr) {
+		return ErrShortFrame
+	}
 
-		if opcodeReplyHasAttr(rep.opcode) {
-			if !reader.ReadU16(&rep.Address, true) {
-				return false
-			}
+	if !pdu.decode(&reader) {
+		return ErrShortFrame
+	}
 
-			if !reader.ReadU16(&rep.lenOrVal, true) {
-				return false
-			}
-		}
+	crc := uint16(0)
+	max := buf.Length() - reader.Length()
 
-		if opcodeReplyHasPayload(rep.opcode) {
-			if opcodeHasErr(rep.opcode) {
-				rep.lenOrVal = 1
-			} else {
-				l := uint8(0)
-				if !reader.ReadU8(&l) {
-					return false
-				}
-				rep.lenOrVal = uint16(l)
-			}
+	if !reader.ReadU16(&crc, false) {
+		return ErrShortFrame
+	}
 
-			if opcodeReplyPayloadBit(rep.opcode) {
-				payload := &_payloadBit{}
-				payload.SetLength(int(rep.lenOrVal) * 8)
-				reader.Read(payload.raws)
-				rep.payload = payload
-			}
+	if rtu_crc16(buf, max) != crc {
+		buf.Skip(1)
+		return ErrChecksum
+	}
 
-			if opcodeReplyPayloadU16(rep.opcode) {
-				payload := &_payloadU16{}
-				payload.SetLength(int(rep.lenOrVal) / 2)
-				reader.Read(payload.raws)
-				rep.payload = payload
-			}
-		}
+	buf.Skip(buf.Length() - reader.Length())
+	return nil
+}
 
-		crc := uint16(0)
-		max := crc_reader.Length() - reader.Length()
+func (p *_rtuParser) unknownFunction(b Buffer) bool {
+	buf, ok := b.(*_buffer)
+	if !ok {
+		buf = scratch(b)
+	}
 
-		if !reader.ReadU16(&crc, false) {
-			return false
-		}
+	if buf.Length() < 2 {
+		return false
+	}
 
-		if rtu_crc16(crc_reader, max) == crc {
-			return true
-		} else {
-			b.ReadU8(addr)
-		}
+	opcode := buf.peek(1)
+	return opcodeHasErr(opcode) || !opcodeAllowed(opcode)
+}
+
+// decodeFunction checks the CRC over everything in b and consumes it.
+func (p *_rtuParser) decodeFunction(addr *uint8, opcode *uint8, b Buffer) error {
+	buf, ok := b.(*_buffer)
+	if !ok {
+		return decodeSlow(b, func(buf *_buffer) error {
+			return p.decodeFunction(addr, opcode, buf)
+		})
+	}
+
+	l := buf.Length()
+	if l < 4 {
+		return ErrShortFrame
+	}
+
+	crc := uint16(buf.peek(l-2)) | uint16(buf.peek(l-1))<<8
+	if rtu_crc16(buf, l-2) != crc {
+		return ErrChecksum
 	}
+
+	*addr = buf.peek(0)
+	*opcode = buf.peek(1)
+	buf.Skip(l)
+	return nil
 }
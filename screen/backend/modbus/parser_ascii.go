@@ -0,0 +1,185 @@
+package modbus
+
+const (
+	ASCII_START = uint8(':')
+	ASCII_CR    = uint8('\r')
+	ASCII_LF    = uint8('\n')
+	ASCII_MAX   = 256
+)
+
+const ascii_digits = "0123456789ABCDEF"
+
+// _asciiParser keeps the binary frame it encodes from and decodes into, so
+// neither allocates. Like the transaction id of the TCP parser, that ties a
+// parser to one connection.
+type _asciiParser struct {
+	frame _buffer
+}
+
+// ascii_lrc sums up to max bytes from the read position of b without
+// consuming them.
+func ascii_lrc(b *_buffer, max int) uint8 {
+	lrc := uint8(0)
+	len := b.Length()
+
+	if len > max {
+		len = max
+	}
+
+	for i := 0; i < len; i++ {
+		lrc += b.peek(i)
+	}
+
+	return -lrc
+}
+
+func ascii_unhex(c uint8) (uint8, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	}
+
+	return 0, false
+}
+
+func NewASCIIParser() Parser {
+	return &_asciiParser{
+		frame: _buffer{
+			capacity: ASCII_MAX,
+			flag:     BUFFER_EMPTY,
+			raws:     make([]uint8, ASCII_MAX),
+		},
+	}
+}
+
+func (p *_asciiParser) Encode(addr *uint8, req *Request, b Buffer) error {
+	return p.encode(addr, _pdu{req: req}, b)
+}
+
+func (p *_asciiParser) Decode(addr *uint8, rep *Reply, b Buffer) error {
+	return p.decode(addr, _pdu{rep: rep}, b, true)
+}
+
+func (p *_asciiParser) EncodeReply(addr *uint8, rep *Reply, b Buffer) error {
+	return p.encode(addr, _pdu{rep: rep}, b)
+}
+
+func (p *_asciiParser) DecodeRequest(addr *uint8, req *Request, b Buffer) error {
+	return p.decode(addr, _pdu{req: req}, b, false)
+}
+
+func (p *_asciiParser) encode(addr *uint8, pdu _pdu, b Buffer) error {
+	buf, ok := b.(*_buffer)
+	if !ok {
+		return encodeSlow(b, func(buf *_buffer) error {
+			return p.encode(addr, pdu, buf)
+		})
+	}
+
+	frame := &p.frame
+	frame.reset()
+	frame.WriteU8(*addr)
+
+	if !pdu.encode(frame) {
+		return ErrEncode
+	}
+
+	frame.WriteU8(ascii_lrc(frame, frame.Length()))
+
+	buf.WriteU8(ASCII_START)
+
+	val := uint8(0)
+	for frame.ReadU8(&val) {
+		buf.WriteU8(ascii_digits[val>>4])
+		buf.WriteU8(ascii_digits[val&0x0F])
+	}
+
+	buf.WriteU8(ASCII_CR)
+	if !buf.WriteU8(ASCII_LF) {
+		return ErrEncode
+	}
+
+	return nil
+}
+
+func (p *_asciiParser) decode(addr *uint8, pdu _pdu, b Buffer, reply bool) error {
+	buf, ok := b.(*_buffer)
+	if !ok {
+		return decodeSlow(b, func(buf *_buffer) error {
+			return p.decode(addr, pdu, buf, reply)
+		})
+	}
+
+	frame := &p.frame
+	for {
+		reader := *buf
+
+		c := uint8(0)
+		if !reader.ReadU8(&c) {
+			return ErrShortFrame
+		}
+
+		if c != ASCII_START {
+			buf.Skip(1)
+			continue
+		}
+
+		frame.reset()
+		complete := false
+		resync := false
+
+		for !complete && !resync {
+			hi := uint8(0)
+			lo := uint8(0)
+
+			if !reader.ReadU8(&hi) {
+				return ErrShortFrame
+			}
+
+			if hi == ASCII_START {
+				resync = true
+				continue
+			}
+
+			if !reader.ReadU8(&lo) {
+				return ErrShortFrame
+			}
+
+			if hi == ASCII_CR {
+				complete = lo == ASCII_LF
+				resync = !complete
+				continue
+			}
+
+			h, hok := ascii_unhex(hi)
+			l, lok := ascii_unhex(lo)
+			if !hok || !lok || frame.IsFull() {
+				resync = true
+				continue
+			}
+
+			frame.WriteU8((h << 4) | l)
+		}
+
+		if !complete || frame.Length() < 3 {
+			buf.Skip(1)
+			continue
+		}
+
+		if ascii_lrc(frame, frame.Length()) != 0 {
+			buf.Skip(1)
+			return ErrChecksum
+		}
+
+		buf.Skip(buf.Length() - reader.Length())
+
+		frame.ReadU8(addr)
+		if pdu.decode(frame) && (frame.Length() == 1 || !reply && frame.Length() > 0) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestASCIIEncode(t *testing.T) {
+	b := NewBuffer(FRAME_MAX)
+	unit := uint8(1)
+	if err := NewASCIIParser().Encode(&unit, readRequest(0x0000, 1), b); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(bytesOf(b)), ":010300000001FB\r\n"; got != want {
+		t.Fatalf("encode = %q, want %q", got, want)
+	}
+}
+
+func TestASCIIDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		err  error
+	}{
+		{"complete", ":010302002AD0\r\n", nil},
+		{"lower case", ":010302002ad0\r\n", nil},
+		{"noise before start", "xx\r\n:010302002AD0\r\n", nil},
+		{"restart mid frame", ":0103:010302002AD0\r\n", nil},
+		{"no terminator", ":010302002AD0", ErrShortFrame},
+		{"bad lrc", ":010302002AD1\r\n", ErrChecksum},
+		{"bad digit", ":0103020G2AD0\r\n", ErrShortFrame},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unit := uint8(0)
+			rep := &Reply{}
+
+			err := NewASCIIParser().Decode(&unit, rep, bufferOf([]byte(tt.in)))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("decode = %v, want %v", err, tt.err)
+			}
+
+			if err == nil && (unit != 1 || rep.Payload().(PayloadU16).Get(0) != 42) {
+				t.Fatalf("decoded unit %d reply %+v", unit, rep)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package modbus
+
+const (
+	MBAP_PROTOCOL = uint16(0x0000)
+	MBAP_HEADER   = 6
+	MBAP_MAX      = 254
+)
+
+type _tcpParser struct {
+	transaction uint16
+}
+
+func NewTCPParser() Parser {
+	return &_tcpParser{}
+}
+
+func (p *_tcpParser) Encode(addr *uint8, req *Request, b Buffer) error {
+	p.transaction++
+	return p.encode(addr, _pdu{req: req}, b)
+}
+
+func (p *_tcpParser) Decode(addr *uint8, rep *Reply, b Buffer) error {
+	return p.decode(addr, _pdu{rep: rep}, b, true)
+}
+
+func (p *_tcpParser) EncodeReply(addr *uint8, rep *Reply, b Buffer) error {
+	return p.encode(addr, _pdu{rep: rep}, b)
+}
+
+func (p *_tcpParser) DecodeRequest(addr *uint8, req *Request, b Buffer) error {
+	return p.decode(addr, _pdu{req: req}, b, false)
+}
+
+func (p *_tcpParser) encode(addr *uint8, pdu _pdu, b Buffer) error {
+	buf, ok := b.(*_buffer)
+	if !ok {
+		return encodeSlow(b, func(buf *_buffer) error {
+			return p.encode(addr, pdu, buf)
+		})
+	}
+
+	start := buf.Length()
+	buf.WriteU16(p.transaction, true)
+	buf.WriteU16(MBAP_PROTOCOL, true)
+	buf.WriteU16(0, true)
+	buf.WriteU8(*addr)
+
+	if !pdu.encode(buf) {
+		return ErrEncode
+	}
+
+	length := buf.Length() - start - MBAP_HEADER
+	if length > MBAP_MAX {
+		return ErrEncode
+	}
+
+	buf.poke(start+4, uint8(length>>8))
+	buf.poke(start+5, uint8(length))
+	return nil
+}
+
+func (p *_tcpParser) decode(addr *uint8, pdu _pdu, b Buffer, reply bool) error {
+	buf, ok := b.(*_buffer)
+	if !ok {
+		return decodeSlow(b, func(buf *_buffer) error {
+			return p.decode(addr, pdu, buf, reply)
+		})
+	}
+
+	for {
+		reader := *buf
+
+		tid := uint16(0)
+		pid := uint16(0)
+		length := uint16(0)
+
+		if !reader.ReadU16(&tid, true) {
+			return ErrShortFrame
+		}
+
+		if !reader.ReadU16(&pid, true) {
+			return ErrShortFrame
+		}
+
+		if !reader.ReadU16(&length, true) {
+			return ErrShortFrame
+		}
+
+		if pid != MBAP_PROTOCOL || length < 2 || length > MBAP_MAX {
+			buf.Skip(1)
+			continue
+		}
+
+		if reader.Length() < int(length) {
+			return ErrShortFrame
+		}
+
+		frame := reader.Length()
+		reader.ReadU8(addr)
+		ok := pdu.decode(&reader)
+		used := frame - reader.Length()
+		buf.Skip(MBAP_HEADER + int(length))
+
+		if reply && ok && tid == p.transaction && used == int(length) {
+			return nil
+		}
+
+		if !reply && ok && used <= int(length) {
+			p.transaction = tid
+			return nil
+		}
+	}
+}
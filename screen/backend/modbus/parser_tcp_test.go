@@ -0,0 +1,62 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTCPEncode(t *testing.T) {
+	p := NewTCPParser()
+	unit := uint8(0x11)
+
+	for tid := 1; tid <= 2; tid++ {
+		b := NewBuffer(FRAME_MAX)
+		if err := p.Encode(&unit, readRequest(0x006B, 3), b); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []byte{0x00, byte(tid), 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x6B, 0x00, 0x03}
+		if got := bytesOf(b); !bytes.Equal(got, want) {
+			t.Fatalf("encode = % x, want % x", got, want)
+		}
+	}
+}
+
+func TestTCPDecode(t *testing.T) {
+	reply := func(tid byte, pid byte) []byte {
+		return []byte{0x00, tid, 0x00, pid, 0x00, 0x05, 0x11, 0x03, 0x02, 0x00, 0x2A}
+	}
+
+	tests := []struct {
+		name string
+		in   []byte
+		err  error
+	}{
+		{"match", reply(1, 0), nil},
+		{"short", reply(1, 0)[:8], ErrShortFrame},
+		{"stale transaction", reply(9, 0), ErrShortFrame},
+		{"stale then match", append(reply(9, 0), reply(1, 0)...), nil},
+		{"bad protocol", append([]byte{0xFF}, reply(1, 0)...), nil},
+		{"foreign protocol", reply(1, 1), ErrShortFrame},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewTCPParser()
+			unit := uint8(0x11)
+			p.Encode(&unit, readRequest(0, 1), NewBuffer(FRAME_MAX))
+
+			rep := &Reply{}
+			unit = 0
+			err := p.Decode(&unit, rep, bufferOf(tt.in))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("decode = %v, want %v", err, tt.err)
+			}
+
+			if err == nil && (unit != 0x11 || rep.Payload().(PayloadU16).Get(0) != 42) {
+				t.Fatalf("decoded unit %d reply %+v", unit, rep)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// crc16Bitwise is the textbook bit-by-bit Modbus CRC, kept as a reference
+// for the table driven one.
+func crc16Bitwise(raws []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, v := range raws {
+		crc ^= uint16(v)
+		for bit := 0; bit < 8; bit++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+func rtuFrame(raws ...byte) []byte {
+	crc := crc16Bitwise(raws)
+	return append(raws, byte(crc), byte(crc>>8))
+}
+
+func bufferOf(raws []byte) Buffer {
+	b := NewBuffer(FRAME_MAX)
+	b.Write(raws)
+	return b
+}
+
+func bytesOf(b Buffer) []byte {
+	raws := make([]byte, b.Length())
+	b.Read(raws)
+	return raws
+}
+
+func readRequest(address uint16, quantity uint16) *Request {
+	req := NewRequest(OPCODE_READ_HOLDING_REGISTERS)
+	req.Address = address
+	req.SetLength(quantity)
+	return req
+}
+
+func TestRTUEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		unit uint8
+		req  *Request
+		want []byte
+	}{
+		{"read holding", 0x01, readRequest(0x0000, 1), []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A}},
+		{"spec example", 0x11, readRequest(0x006B, 3), []byte{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03, 0x76, 0x87}},
+		{"write register", 0x11, func() *Request {
+			req := NewRequest(OPCODE_WRITE_REGISTER)
+			req.Address = 0x0001
+			req.SetLength(0x0003)
+			return req
+		}(), []byte{0x11, 0x06, 0x00, 0x01, 0x00, 0x03, 0x9A, 0x9B}},
+		{"write coil", 0x01, func() *Request {
+			req := NewRequest(OPCODE_WRITE_COIL)
+			req.Address = 0x00AC
+			req.SetValue(true)
+			return req
+		}(), rtuFrame(0x01, 0x05, 0x00, 0xAC, 0xFF, 0x00)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuffer(FRAME_MAX)
+			if err := NewRTUParser().Encode(&tt.unit, tt.req, b); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := bytesOf(b); !bytes.Equal(got, tt.want) {
+				t.Fatalf("encode = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRTUDecode(t *testing.T) {
+	good := rtuFrame(0x01, 0x03, 0x02, 0x00, 0x05)
+	bad := append([]byte{}, good...)
+	bad[4] ^= 0xFF
+
+	tests := []struct {
+		name string
+		in   []byte
+		err  error
+		left int
+	}{
+		{"complete", good, nil, 0},
+		{"short", good[:4], ErrShortFrame, 4},
+		{"corrupt", bad, ErrChecksum, len(bad) - 1},
+		{"trailing", append(append([]byte{}, good...), 0x01), nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := bufferOf(tt.in)
+			unit := uint8(0)
+			rep := &Reply{}
+
+			err := NewRTUParser().Decode(&unit, rep, b)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("decode = %v, want %v", err, tt.err)
+			}
+
+			if b.Length() != tt.left {
+				t.Fatalf("left %d bytes, want %d", b.Length(), tt.left)
+			}
+
+			if err == nil && (unit != 1 || rep.Payload().(PayloadU16).Get(0) != 5) {
+				t.Fatalf("decoded unit %d value %d", unit, rep.Payload().(PayloadU16).Get(0))
+			}
+		})
+	}
+}
+
+func TestRTUDecodeResync(t *testing.T) {
+	b := bufferOf(append([]byte{0x55}, rtuFrame(0x81, 0x83, 0x02)...))
+	unit := uint8(0)
+	rep := &Reply{}
+
+	err := NewRTUParser().Decode(&unit, rep, b)
+	for err == ErrChecksum {
+		err = NewRTUParser().Decode(&unit, rep, b)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var exc *ExceptionError
+	if !errors.As(rep.Exception(), &exc) || exc.Function != 0x03 || exc.Code != EXCEPTION_ILLEGAL_DATA_ADDRESS {
+		t.Fatalf("exception = %v", rep.Exception())
+	}
+}
+
+func TestParserRequestRoundTrip(t *testing.T) {
+	parsers := map[string]func() Parser{
+		"rtu":   NewRTUParser,
+		"ascii": NewASCIIParser,
+		"tcp":   NewTCPParser,
+	}
+
+	write := NewRequest(OPCODE_WRITE_REGISTERS)
+	write.Address = 0x0102
+	write.SetLength(2)
+	write.Payload().(PayloadU16).Set(0, 0xBEEF)
+	write.Payload().(PayloadU16).Set(1, 0x0042)
+
+	for name, parser := range parsers {
+		t.Run(name, func(t *testing.T) {
+			p := parser()
+			b := NewBuffer(FRAME_MAX)
+			unit := uint8(7)
+			if err := p.Encode(&unit, write, b); err != nil {
+				t.Fatal(err)
+			}
+
+			got := &Request{}
+			unit = 0
+			if err := p.DecodeRequest(&unit, got, b); err != nil {
+				t.Fatal(err)
+			}
+
+			pyd, ok := got.Payload().(PayloadU16)
+			if unit != 7 || got.OpCode() != OPCODE_WRITE_REGISTERS || got.Address != 0x0102 || got.lenOrVal != 2 || !ok {
+				t.Fatalf("decoded unit %d request %+v", unit, got)
+			}
+
+			if pyd.Get(0) != 0xBEEF || pyd.Get(1) != 0x0042 {
+				t.Fatalf("decoded values %04x %04x", pyd.Get(0), pyd.Get(1))
+			}
+		})
+	}
+}
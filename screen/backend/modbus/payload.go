@@ -30,6 +30,20 @@ type _payloadU16 struct {
 	raws []uint8
 }
 
+// resize returns a zeroed slice of n bytes, reusing raws when it is large
+// enough.
+func resize(raws []uint8, n int) []uint8 {
+	if cap(raws) < n {
+		return make([]uint8, n)
+	}
+
+	raws = raws[:n]
+	for i := range raws {
+		raws[i] = 0
+	}
+	return raws
+}
+
 func (p *_payloadBit) IsBit() bool {
 	return true
 }
@@ -44,7 +58,7 @@ func (p *_payloadBit) SetLength(v int) {
 		vlen += 1
 	}
 
-	p.raws = make([]uint8, vlen)
+	p.raws = resize(p.raws, vlen)
 }
 
 func (p *_payloadBit) WriteTo(b Buffer) bool {
@@ -79,7 +93,7 @@ func (p *_payloadU16) IsU16() bool {
 }
 
 func (p *_payloadU16) SetLength(v int) {
-	p.raws = make([]uint8, v*2)
+	p.raws = resize(p.raws, v*2)
 }
 
 func (p *_payloadU16) WriteTo(b Buffer) bool {
@@ -0,0 +1,127 @@
+package modbus
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+type priorityKey struct{}
+
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+
+	return PriorityNormal
+}
+
+type _waiter struct {
+	priority Priority
+	sequence uint64
+	ready    chan struct{}
+	index    int
+}
+
+type _waiters []*_waiter
+
+func (w _waiters) Len() int {
+	return len(w)
+}
+
+func (w _waiters) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+
+	return w[i].sequence < w[j].sequence
+}
+
+func (w _waiters) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+
+func (w *_waiters) Push(x any) {
+	v := x.(*_waiter)
+	v.index = len(*w)
+	*w = append(*w, v)
+}
+
+func (w *_waiters) Pop() any {
+	old := *w
+	n := len(old)
+	v := old[n-1]
+	old[n-1] = nil
+	v.index = -1
+	*w = old[:n-1]
+	return v
+}
+
+type _queue struct {
+	mu       sync.Mutex
+	busy     bool
+	sequence uint64
+	waiters  _waiters
+}
+
+func (q *_queue) acquire(ctx context.Context, p Priority) error {
+	q.mu.Lock()
+	if !q.busy {
+		q.busy = true
+		q.mu.Unlock()
+		return nil
+	}
+
+	q.sequence++
+	w := &_waiter{
+		priority: p,
+		sequence: q.sequence,
+		ready:    make(chan struct{}),
+	}
+	heap.Push(&q.waiters, w)
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	if w.index < 0 {
+		q.mu.Unlock()
+		q.release()
+		return ctx.Err()
+	}
+
+	heap.Remove(&q.waiters, w.index)
+	q.mu.Unlock()
+	return ctx.Err()
+}
+
+func (q *_queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.waiters.Len() == 0 {
+		q.busy = false
+		return
+	}
+
+	w := heap.Pop(&q.waiters).(*_waiter)
+	close(w.ready)
+}
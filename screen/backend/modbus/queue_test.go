@@ -0,0 +1,138 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitQueued blocks until n waiters are parked on q.
+func waitQueued(t *testing.T, q *_queue, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		q.mu.Lock()
+		got := q.waiters.Len()
+		q.mu.Unlock()
+		if got == n {
+			return
+		}
+	}
+	t.Fatalf("never saw %d waiters", n)
+}
+
+func TestQueueOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []Priority
+		want       []int
+	}{
+		{"fifo", []Priority{PriorityNormal, PriorityNormal, PriorityNormal}, []int{0, 1, 2}},
+		{"high first", []Priority{PriorityLow, PriorityNormal, PriorityHigh}, []int{2, 1, 0}},
+		{"fifo within priority", []Priority{PriorityLow, PriorityHigh, PriorityLow, PriorityHigh}, []int{1, 3, 0, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &_queue{}
+			q.acquire(context.Background(), PriorityNormal)
+
+			order := make(chan int, len(tt.priorities))
+			for i, p := range tt.priorities {
+				go func(i int, p Priority) {
+					q.acquire(context.Background(), p)
+					order <- i
+					q.release()
+				}(i, p)
+				waitQueued(t, q, i+1)
+			}
+
+			q.release()
+			for _, want := range tt.want {
+				if got := <-order; got != want {
+					t.Fatalf("waiter %d ran, want %d", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	q := &_queue{}
+	q.acquire(context.Background(), PriorityNormal)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- q.acquire(ctx, PriorityHigh) }()
+	waitQueued(t, q, 1)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("acquire = %v, want context.Canceled", err)
+	}
+	waitQueued(t, q, 0)
+
+	q.release()
+	if q.busy {
+		t.Fatal("queue still busy after the only holder released it")
+	}
+}
+
+func TestQueueCancelAfterHandoff(t *testing.T) {
+	// A waiter whose context ends just as the queue is handed to it must
+	// pass the queue on, not leak it.
+	for i := 0; i < 100; i++ {
+		q := &_queue{}
+		q.acquire(context.Background(), PriorityNormal)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			err := q.acquire(ctx, PriorityNormal)
+			if err == nil {
+				q.release()
+			}
+			done <- err
+		}()
+		waitQueued(t, q, 1)
+
+		go cancel()
+		q.release()
+		<-done
+
+		ctx, stop := context.WithTimeout(context.Background(), time.Second)
+		if err := q.acquire(ctx, PriorityNormal); err != nil {
+			t.Fatalf("queue leaked: %v", err)
+		}
+		stop()
+	}
+}
+
+func TestExecPriority(t *testing.T) {
+	d := &memDriver{gap: 20 * time.Millisecond}
+	d.respond = func(req []byte) [][]byte {
+		return [][]byte{rtuFrame(req[0], 0x03, 0x02, 0x00, req[0])}
+	}
+	m := newTestModbus(d)
+
+	// Hold the line so the two requests below have to queue.
+	m.queue.acquire(context.Background(), PriorityNormal)
+
+	done := make(chan uint8, 2)
+	for _, tt := range []struct {
+		unit     uint8
+		priority Priority
+	}{{1, PriorityLow}, {2, PriorityHigh}} {
+		go func(unit uint8, p Priority) {
+			m.ExecContext(WithPriority(context.Background(), p), unit, readRequest(0, 1))
+			done <- unit
+		}(tt.unit, tt.priority)
+		waitQueued(t, &m.queue, int(tt.unit))
+	}
+
+	m.queue.release()
+	if first, second := <-done, <-done; first != 2 || second != 1 {
+		t.Fatalf("units answered %d then %d, want the high priority unit 2 first", first, second)
+	}
+}
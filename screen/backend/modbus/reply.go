@@ -1,10 +1,23 @@
 package modbus
 
+type DeviceObject struct {
+	ID    uint8
+	Value []byte
+}
+
 type Reply struct {
-	Address  uint16
-	opcode   uint8
-	lenOrVal uint16
-	payload  Payload
+	Address    uint16
+	opcode     uint8
+	lenOrVal   uint16
+	andMask    uint16
+	orMask     uint16
+	mei        uint8
+	conformity uint8
+	more       bool
+	next       uint8
+	objects    []DeviceObject
+	records    []FileRecord
+	payload    Payload
 }
 
 func (r *Reply) OpCode() uint8 {
@@ -19,6 +32,314 @@ func (r *Reply) Value() bool {
 	return r.lenOrVal == 0xFF00
 }
 
+func (r *Reply) Mask() (uint16, uint16) {
+	return r.andMask, r.orMask
+}
+
+func (r *Reply) Objects() []DeviceObject {
+	return r.objects
+}
+
+func (r *Reply) Records() []FileRecord {
+	return r.records
+}
+
 func (r *Reply) Payload() Payload {
 	return r.payload
 }
+
+func (r *Reply) Exception() error {
+	if !opcodeHasErr(r.opcode) {
+		return nil
+	}
+
+	return &ExceptionError{
+		Function: opcodeMask(r.opcode),
+		Code:     uint8(r.lenOrVal),
+	}
+}
+
+// matches checks what the reply echoes of req: the byte count of a read, or
+// the address and value or quantity of a write. On RTU that is all there is
+// to tell the answer apart from a late one to an earlier request.
+func (r *Reply) matches(req *Request) bool {
+	switch req.opcode {
+	case OPCODE_READ_COILS, OPCODE_DISCRETE_INPUTS:
+		return r.lenOrVal == (req.lenOrVal+7)/8
+	case OPCODE_READ_HOLDING_REGISTERS, OPCODE_READ_INPUT_REGISTERS, OPCODE_READ_WRITE_REGISTERS:
+		return r.lenOrVal == req.lenOrVal*2
+	case OPCODE_WRITE_COIL, OPCODE_WRITE_REGISTER, OPCODE_WRITE_COILS, OPCODE_WRITE_REGISTERS:
+		return r.Address == req.Address && r.lenOrVal == req.lenOrVal
+	case OPCODE_MASK_WRITE_REGISTER:
+		return r.Address == req.Address && r.andMask == req.andMask && r.orMask == req.orMask
+	case OPCODE_DIAGNOSTICS:
+		return r.Address == req.Address
+	}
+
+	return true
+}
+
+func (r *Reply) decode(b *_buffer) bool {
+	if !b.ReadU8(&r.opcode) {
+		return false
+	}
+
+	if !opcodeReplyHasPayload(r.opcode) {
+		r.payload = nil
+	}
+
+	if opcodeHasErr(r.opcode) {
+		code := uint8(0)
+		if !b.ReadU8(&code) {
+			return false
+		}
+
+		r.lenOrVal = uint16(code)
+		r.payload = nil
+		return true
+	}
+
+	if r.opcode == OPCODE_ENCAPSULATED_INTERFACE {
+		return r.decodeDeviceID(b)
+	}
+
+	if r.opcode == OPCODE_READ_FILE_RECORD || r.opcode == OPCODE_WRITE_FILE_RECORD {
+		return r.decodeRecords(b)
+	}
+
+	if r.opcode == OPCODE_MASK_WRITE_REGISTER {
+		if !b.ReadU16(&r.Address, true) {
+			return false
+		}
+
+		if !b.ReadU16(&r.andMask, true) {
+			return false
+		}
+
+		return b.ReadU16(&r.orMask, true)
+	}
+
+	if opcodeReplyHasAttr(r.opcode) {
+		if !b.ReadU16(&r.Address, true) {
+			return false
+		}
+
+		if !b.ReadU16(&r.lenOrVal, true) {
+			return false
+		}
+	}
+
+	if opcodeReplyHasPayload(r.opcode) {
+		l := uint8(0)
+		if !b.ReadU8(&l) {
+			return false
+		}
+		r.lenOrVal = uint16(l)
+
+		if opcodeReplyPayloadBit(r.opcode) {
+			payload, ok := r.payload.(*_payloadBit)
+			if !ok {
+				payload = &_payloadBit{}
+				r.payload = payload
+			}
+			payload.SetLength(int(r.lenOrVal) * 8)
+			b.Read(payload.raws)
+		}
+
+		if opcodeReplyPayloadU16(r.opcode) {
+			payload, ok := r.payload.(*_payloadU16)
+			if !ok {
+				payload = &_payloadU16{}
+				r.payload = payload
+			}
+			payload.SetLength(int(r.lenOrVal) / 2)
+			b.Read(payload.raws)
+		}
+	}
+
+	return true
+}
+
+func (r *Reply) decodeDeviceID(b *_buffer) bool {
+	code := uint8(0)
+	more := uint8(0)
+	count := uint8(0)
+
+	if !b.ReadU8(&r.mei) {
+		return false
+	}
+
+	if r.mei != MEI_READ_DEVICE_ID {
+		return true
+	}
+
+	if !b.ReadU8(&code) || !b.ReadU8(&r.conformity) || !b.ReadU8(&more) {
+		return false
+	}
+
+	if !b.ReadU8(&r.next) || !b.ReadU8(&count) {
+		return false
+	}
+
+	r.lenOrVal = uint16(code)
+	r.more = more == 0xFF
+	r.objects = make([]DeviceObject, count)
+
+	for i := range r.objects {
+		l := uint8(0)
+		if !b.ReadU8(&r.objects[i].ID) || !b.ReadU8(&l) {
+			return false
+		}
+
+		r.objects[i].Value = make([]byte, l)
+		if b.Read(r.objects[i].Value) != int(l) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *Reply) decodeRecords(b *_buffer) bool {
+	size := uint8(0)
+	if !b.ReadU8(&size) {
+		return false
+	}
+
+	r.lenOrVal = uint16(size)
+	r.records = r.records[:0]
+
+	for remain := int(size); remain > 0; {
+		rec := FileRecord{}
+		ref := uint8(0)
+
+		if r.opcode == OPCODE_READ_FILE_RECORD {
+			l := uint8(0)
+			if !b.ReadU8(&l) || !b.ReadU8(&ref) || l == 0 {
+				return false
+			}
+
+			rec.Length = uint16(l-1) / 2
+			remain -= 1 + int(l)
+		} else {
+			if !b.ReadU8(&ref) || !b.ReadU16(&rec.File, true) {
+				return false
+			}
+
+			if !b.ReadU16(&rec.Record, true) || !b.ReadU16(&rec.Length, true) {
+				return false
+			}
+
+			remain -= 7 + int(rec.Length)*2
+		}
+
+		if remain < 0 {
+			return false
+		}
+
+		rec.Data = make([]uint16, rec.Length)
+		for i := range rec.Data {
+			if !b.ReadU16(&rec.Data[i], true) {
+				return false
+			}
+		}
+
+		r.records = append(r.records, rec)
+	}
+
+	return true
+}
+
+func (r *Reply) encode(b *_buffer) bool {
+	b.WriteU8(r.opcode)
+
+	if opcodeHasErr(r.opcode) {
+		return b.WriteU8(uint8(r.lenOrVal))
+	}
+
+	switch r.opcode {
+	case OPCODE_ENCAPSULATED_INTERFACE:
+		return r.encodeDeviceID(b)
+	case OPCODE_READ_FILE_RECORD, OPCODE_WRITE_FILE_RECORD:
+		return r.encodeRecords(b)
+	case OPCODE_MASK_WRITE_REGISTER:
+		b.WriteU16(r.Address, true)
+		b.WriteU16(r.andMask, true)
+		return b.WriteU16(r.orMask, true)
+	}
+
+	if opcodeReplyHasAttr(r.opcode) {
+		b.WriteU16(r.Address, true)
+		if !b.WriteU16(r.lenOrVal, true) {
+			return false
+		}
+	}
+
+	if opcodeReplyHasPayload(r.opcode) {
+		return r.payload != nil && r.payload.WriteTo(b)
+	}
+
+	return true
+}
+
+func (r *Reply) encodeDeviceID(b *_buffer) bool {
+	more := uint8(0x00)
+	if r.more {
+		more = 0xFF
+	}
+
+	b.WriteU8(r.mei)
+	b.WriteU8(uint8(r.lenOrVal))
+	b.WriteU8(r.conformity)
+	b.WriteU8(more)
+	b.WriteU8(r.next)
+
+	if !b.WriteU8(uint8(len(r.objects))) {
+		return false
+	}
+
+	for _, obj := range r.objects {
+		b.WriteU8(obj.ID)
+		b.WriteU8(uint8(len(obj.Value)))
+		if b.Write(obj.Value) != len(obj.Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *Reply) encodeRecords(b *_buffer) bool {
+	size := 0
+	for _, rec := range r.records {
+		if r.opcode == OPCODE_READ_FILE_RECORD {
+			size += rec.replySize()
+		} else {
+			size += rec.writeSize()
+		}
+	}
+
+	if size > FILE_DATA_MAX || !b.WriteU8(uint8(size)) {
+		return false
+	}
+
+	for _, rec := range r.records {
+		if r.opcode == OPCODE_READ_FILE_RECORD {
+			b.WriteU8(uint8(1 + len(rec.Data)*2))
+			b.WriteU8(FILE_REFERENCE_TYPE)
+		} else {
+			b.WriteU8(FILE_REFERENCE_TYPE)
+			b.WriteU16(rec.File, true)
+			b.WriteU16(rec.Record, true)
+			b.WriteU16(uint16(len(rec.Data)), true)
+		}
+
+		for _, v := range rec.Data {
+			if !b.WriteU16(v, true) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,41 @@
+package modbus
+
+import "testing"
+
+func TestReplyMatches(t *testing.T) {
+	write := NewRequest(OPCODE_WRITE_REGISTER)
+	write.Address = 0x0010
+	write.SetLength(0x1234)
+
+	coils := NewRequest(OPCODE_READ_COILS)
+	coils.SetLength(10)
+
+	mask := NewRequest(OPCODE_MASK_WRITE_REGISTER)
+	mask.Address = 4
+	mask.SetMask(0x00F2, 0x0025)
+
+	tests := []struct {
+		name string
+		req  *Request
+		rep  Reply
+		want bool
+	}{
+		{"registers", readRequest(0, 2), Reply{opcode: OPCODE_READ_HOLDING_REGISTERS, lenOrVal: 4}, true},
+		{"registers short", readRequest(0, 2), Reply{opcode: OPCODE_READ_HOLDING_REGISTERS, lenOrVal: 2}, false},
+		{"coils", coils, Reply{opcode: OPCODE_READ_COILS, lenOrVal: 2}, true},
+		{"coils long", coils, Reply{opcode: OPCODE_READ_COILS, lenOrVal: 3}, false},
+		{"write", write, Reply{opcode: OPCODE_WRITE_REGISTER, Address: 0x0010, lenOrVal: 0x1234}, true},
+		{"write other address", write, Reply{opcode: OPCODE_WRITE_REGISTER, Address: 0x0011, lenOrVal: 0x1234}, false},
+		{"write other value", write, Reply{opcode: OPCODE_WRITE_REGISTER, Address: 0x0010, lenOrVal: 0x4321}, false},
+		{"mask", mask, Reply{opcode: OPCODE_MASK_WRITE_REGISTER, Address: 4, andMask: 0x00F2, orMask: 0x0025}, true},
+		{"mask other", mask, Reply{opcode: OPCODE_MASK_WRITE_REGISTER, Address: 4, andMask: 0x00F2, orMask: 0x0024}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rep.matches(tt.req); got != tt.want {
+				t.Fatalf("matches = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
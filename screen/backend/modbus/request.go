@@ -1,10 +1,35 @@
 package modbus
 
+type FileRecord struct {
+	File   uint16
+	Record uint16
+	Length uint16
+	Data   []uint16
+}
+
+func (f *FileRecord) readSize() int {
+	return 7
+}
+
+func (f *FileRecord) replySize() int {
+	return 2 + int(f.Length)*2
+}
+
+func (f *FileRecord) writeSize() int {
+	return 7 + len(f.Data)*2
+}
+
 type Request struct {
-	Address  uint16
-	opcode   uint8
-	lenOrVal uint16
-	payload  Payload
+	Address      uint16
+	WriteAddress uint16
+	opcode       uint8
+	lenOrVal     uint16
+	writeLen     uint16
+	andMask      uint16
+	orMask       uint16
+	mei          uint8
+	records      []FileRecord
+	payload      Payload
 }
 
 func NewRequest(opcode uint8) *Request {
@@ -32,11 +57,24 @@ func (r *Request) OpCode() uint8 {
 func (r *Request) SetLength(val uint16) {
 	r.lenOrVal = val
 
+	if r.payload != nil && r.opcode != OPCODE_READ_WRITE_REGISTERS {
+		r.payload.SetLength(int(val))
+	}
+}
+
+func (r *Request) SetWriteLength(val uint16) {
+	r.writeLen = val
+
 	if r.payload != nil {
 		r.payload.SetLength(int(val))
 	}
 }
 
+func (r *Request) SetMask(and uint16, or uint16) {
+	r.andMask = and
+	r.orMask = or
+}
+
 func (r *Request) SetValue(val bool) {
 	r.lenOrVal = 0x0000
 
@@ -45,6 +83,255 @@ func (r *Request) SetValue(val bool) {
 	}
 }
 
+func (r *Request) SetDeviceID(code uint8, object uint8) {
+	r.mei = MEI_READ_DEVICE_ID
+	r.lenOrVal = (uint16(code) << 8) | uint16(object)
+}
+
+func (r *Request) AddRecord(rec FileRecord) {
+	if r.opcode == OPCODE_WRITE_FILE_RECORD {
+		rec.Length = uint16(len(rec.Data))
+	}
+
+	r.records = append(r.records, rec)
+}
+
+func (r *Request) Records() []FileRecord {
+	return r.records
+}
+
 func (r *Request) Payload() Payload {
 	return r.payload
 }
+
+func (r *Request) validateRecords() error {
+	request := 0
+	reply := 0
+
+	if len(r.records) == 0 {
+		return ErrQuantity
+	}
+
+	for i := range r.records {
+		rec := &r.records[i]
+		if rec.Length == 0 {
+			return ErrQuantity
+		}
+
+		if rec.File == 0 || int(rec.Record)+int(rec.Length) > FILE_RECORD_MAX {
+			return ErrAddress
+		}
+
+		if r.opcode == OPCODE_READ_FILE_RECORD {
+			request += rec.readSize()
+			reply += rec.replySize()
+		} else {
+			request += rec.writeSize()
+			reply += rec.writeSize()
+		}
+	}
+
+	if request > FILE_DATA_MAX || reply > FILE_DATA_MAX {
+		return ErrQuantity
+	}
+
+	if r.opcode == OPCODE_READ_FILE_RECORD && request > FILE_REQUEST_MAX {
+		return ErrQuantity
+	}
+
+	return nil
+}
+
+func (r *Request) validate() error {
+	if r.opcode == OPCODE_READ_FILE_RECORD || r.opcode == OPCODE_WRITE_FILE_RECORD {
+		return r.validateRecords()
+	}
+
+	max := opcodeQuantityMax(r.opcode)
+	if max == 0 {
+		return nil
+	}
+
+	if r.lenOrVal == 0 || int(r.lenOrVal) > max {
+		return ErrQuantity
+	}
+
+	if int(r.Address)+int(r.lenOrVal) > ADDRESS_MAX {
+		return ErrAddress
+	}
+
+	if r.opcode == OPCODE_READ_WRITE_REGISTERS {
+		if r.writeLen == 0 || r.writeLen > READ_WRITE_REGISTERS_MAX {
+			return ErrQuantity
+		}
+
+		if int(r.WriteAddress)+int(r.writeLen) > ADDRESS_MAX {
+			return ErrAddress
+		}
+	}
+
+	return nil
+}
+
+func (r *Request) encode(b *_buffer) bool {
+	b.WriteU8(r.opcode)
+
+	if r.opcode == OPCODE_ENCAPSULATED_INTERFACE {
+		b.WriteU8(r.mei)
+		return b.WriteU16(r.lenOrVal, true)
+	}
+
+	if r.opcode == OPCODE_READ_FILE_RECORD || r.opcode == OPCODE_WRITE_FILE_RECORD {
+		return r.encodeRecords(b)
+	}
+
+	b.WriteU16(r.Address, true)
+
+	if r.opcode == OPCODE_MASK_WRITE_REGISTER {
+		b.WriteU16(r.andMask, true)
+		return b.WriteU16(r.orMask, true)
+	}
+
+	b.WriteU16(r.lenOrVal, true)
+
+	if r.opcode == OPCODE_READ_WRITE_REGISTERS {
+		b.WriteU16(r.WriteAddress, true)
+		b.WriteU16(r.writeLen, true)
+	}
+
+	if opcodeRequestHaspayload(r.opcode) {
+		if !r.payload.WriteTo(b) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *Request) encodeRecords(b *_buffer) bool {
+	size := 0
+	for i := range r.records {
+		if r.opcode == OPCODE_READ_FILE_RECORD {
+			size += r.records[i].readSize()
+		} else {
+			size += r.records[i].writeSize()
+		}
+	}
+
+	if size > FILE_DATA_MAX {
+		return false
+	}
+
+	b.WriteU8(uint8(size))
+	for _, rec := range r.records {
+		b.WriteU8(FILE_REFERENCE_TYPE)
+		b.WriteU16(rec.File, true)
+		b.WriteU16(rec.Record, true)
+		b.WriteU16(rec.Length, true)
+
+		if r.opcode != OPCODE_WRITE_FILE_RECORD {
+			continue
+		}
+
+		for _, v := range rec.Data {
+			if !b.WriteU16(v, true) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (r *Request) decode(b *_buffer) bool {
+	if !b.ReadU8(&r.opcode) {
+		return false
+	}
+
+	r.payload = nil
+	r.records = nil
+
+	switch r.opcode {
+	case OPCODE_ENCAPSULATED_INTERFACE:
+		return b.ReadU8(&r.mei) && b.ReadU16(&r.lenOrVal, true)
+	case OPCODE_READ_FILE_RECORD, OPCODE_WRITE_FILE_RECORD:
+		return r.decodeRecords(b)
+	case OPCODE_MASK_WRITE_REGISTER:
+		return b.ReadU16(&r.Address, true) && b.ReadU16(&r.andMask, true) && b.ReadU16(&r.orMask, true)
+	}
+
+	if opcodeHasErr(r.opcode) || !opcodeAllowed(r.opcode) {
+		return true
+	}
+
+	if !b.ReadU16(&r.Address, true) || !b.ReadU16(&r.lenOrVal, true) {
+		return false
+	}
+
+	if r.opcode == OPCODE_READ_WRITE_REGISTERS {
+		if !b.ReadU16(&r.WriteAddress, true) || !b.ReadU16(&r.writeLen, true) {
+			return false
+		}
+	}
+
+	if opcodeRequestHaspayload(r.opcode) {
+		count := uint8(0)
+		if !b.ReadU8(&count) {
+			return false
+		}
+
+		raws := make([]uint8, count)
+		if b.Read(raws) != int(count) {
+			return false
+		}
+
+		if opcodeRequestPayloadBit(r.opcode) {
+			r.payload = &_payloadBit{raws: raws}
+		} else {
+			r.payload = &_payloadU16{raws: raws}
+		}
+	}
+
+	return true
+}
+
+func (r *Request) decodeRecords(b *_buffer) bool {
+	size := uint8(0)
+	if !b.ReadU8(&size) {
+		return false
+	}
+
+	for remain := int(size); remain > 0; {
+		rec := FileRecord{}
+		ref := uint8(0)
+
+		if !b.ReadU8(&ref) || !b.ReadU16(&rec.File, true) {
+			return false
+		}
+
+		if !b.ReadU16(&rec.Record, true) || !b.ReadU16(&rec.Length, true) {
+			return false
+		}
+
+		remain -= rec.readSize()
+
+		if r.opcode == OPCODE_WRITE_FILE_RECORD {
+			rec.Data = make([]uint16, rec.Length)
+			for i := range rec.Data {
+				if !b.ReadU16(&rec.Data[i], true) {
+					return false
+				}
+			}
+
+			remain -= int(rec.Length) * 2
+		}
+
+		if remain < 0 {
+			return false
+		}
+
+		r.records = append(r.records, rec)
+	}
+
+	return true
+}
@@ -0,0 +1,118 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// checkPDU encodes req, compares it with want, then decodes want and checks
+// that it encodes back to the same bytes.
+func checkPDU(t *testing.T, req *Request, want []byte) {
+	t.Helper()
+
+	if err := req.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBuffer(FRAME_MAX)
+	if !req.encode(b.(*_buffer)) {
+		t.Fatal("encode failed")
+	}
+
+	if got := bytesOf(b); !bytes.Equal(got, want) {
+		t.Fatalf("encode = % x, want % x", got, want)
+	}
+
+	got := &Request{}
+	if !got.decode(bufferOf(want).(*_buffer)) {
+		t.Fatal("decode failed")
+	}
+
+	b = NewBuffer(FRAME_MAX)
+	got.encode(b.(*_buffer))
+	if again := bytesOf(b); !bytes.Equal(again, want) {
+		t.Fatalf("decoded request encodes to % x", again)
+	}
+}
+
+func TestRequestEncode(t *testing.T) {
+	mask := NewRequest(OPCODE_MASK_WRITE_REGISTER)
+	mask.Address = 0x0004
+	mask.SetMask(0x00F2, 0x0025)
+
+	readWrite := NewRequest(OPCODE_READ_WRITE_REGISTERS)
+	readWrite.Address = 0x0003
+	readWrite.WriteAddress = 0x000E
+	readWrite.SetLength(6)
+	readWrite.SetWriteLength(3)
+	for i := 0; i < 3; i++ {
+		readWrite.Payload().(PayloadU16).Set(i, 0x00FF)
+	}
+
+	// The expected PDUs are the examples from the Modbus application
+	// protocol specification.
+	tests := []struct {
+		name string
+		req  *Request
+		want []byte
+	}{
+		{"mask write register", mask, []byte{0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25}},
+		{"read write registers", readWrite, []byte{
+			0x17, 0x00, 0x03, 0x00, 0x06, 0x00, 0x0E, 0x00, 0x03, 0x06,
+			0x00, 0xFF, 0x00, 0xFF, 0x00, 0xFF,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkPDU(t, tt.req, tt.want)
+		})
+	}
+}
+
+func TestRequestValidate(t *testing.T) {
+	readWrite := func(read uint16, write uint16, address uint16) *Request {
+		req := NewRequest(OPCODE_READ_WRITE_REGISTERS)
+		req.WriteAddress = address
+		req.SetLength(read)
+		req.SetWriteLength(write)
+		return req
+	}
+
+	tests := []struct {
+		name string
+		req  *Request
+		err  error
+	}{
+		{"read write ok", readWrite(READ_REGISTERS_MAX, READ_WRITE_REGISTERS_MAX, 0), nil},
+		{"read write no writes", readWrite(1, 0, 0), ErrQuantity},
+		{"read write too many writes", readWrite(1, READ_WRITE_REGISTERS_MAX+1, 0), ErrQuantity},
+		{"read write past the end", readWrite(1, 2, 0xFFFF), ErrAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.validate(); !errors.Is(err, tt.err) {
+				t.Fatalf("validate = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMaskWriteRegister(t *testing.T) {
+	d := &memDriver{respond: func(req []byte) [][]byte {
+		return [][]byte{rtuFrame(req[:len(req)-2]...)}
+	}}
+	m := newTestModbus(d)
+
+	if err := m.MaskWriteRegister(context.Background(), 1, 0x0004, 0x00F2, 0x0025); err != nil {
+		t.Fatal(err)
+	}
+
+	want := rtuFrame(0x01, 0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25)
+	if !bytes.Equal(d.sent[0], want) {
+		t.Fatalf("sent % x, want % x", d.sent[0], want)
+	}
+}
@@ -0,0 +1,57 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Retryable  func(err error) bool
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   3,
+	Backoff:    50 * time.Millisecond,
+	MaxBackoff: 500 * time.Millisecond,
+	Retryable:  IsRetryable,
+}
+
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var exc *ExceptionError
+	if errors.As(err, &exc) {
+		return exc.Code == EXCEPTION_SLAVE_DEVICE_BUSY
+	}
+
+	return errors.Is(err, ErrTimeout) ||
+		errors.Is(err, ErrChecksum) ||
+		errors.Is(err, ErrShortFrame) ||
+		errors.Is(err, ErrUnexpected)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable == nil {
+		return IsRetryable(err)
+	}
+
+	return p.Retryable(err)
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if p.MaxBackoff > 0 && wait >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+
+	return wait
+}
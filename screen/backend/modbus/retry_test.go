@@ -0,0 +1,100 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{ErrTimeout, true},
+		{ErrChecksum, true},
+		{ErrShortFrame, true},
+		{ErrUnexpected, true},
+		{fmt.Errorf("wrapped: %w", ErrTimeout), true},
+		{&ExceptionError{Function: 3, Code: EXCEPTION_SLAVE_DEVICE_BUSY}, true},
+		{&ExceptionError{Function: 3, Code: EXCEPTION_ILLEGAL_DATA_ADDRESS}, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{ErrQuantity, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Fatalf("IsRetryable = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: 50 * time.Millisecond, MaxBackoff: 150 * time.Millisecond}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 50 * time.Millisecond},
+		{2, 100 * time.Millisecond},
+		{3, 150 * time.Millisecond},
+		{9, 150 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := p.backoff(tt.attempt); got != tt.want {
+			t.Fatalf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestExecRetries(t *testing.T) {
+	calls := 0
+	d := &memDriver{}
+	d.respond = func(req []byte) [][]byte {
+		calls++
+		if calls < 3 {
+			return nil
+		}
+		return [][]byte{rtuFrame(0x01, 0x03, 0x02, 0x00, 0x07)}
+	}
+
+	m := newTestModbus(d)
+	m.SetTimeout(20 * time.Millisecond)
+	m.SetRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond})
+
+	if _, err := m.Exec(1, readRequest(0, 1)); err != nil || calls != 3 {
+		t.Fatalf("exec = %v after %d attempts", err, calls)
+	}
+
+	calls = 0
+	m.SetRetryPolicy(RetryPolicy{Attempts: 2, Backoff: time.Millisecond})
+	if _, err := m.Exec(1, readRequest(0, 1)); !errors.Is(err, ErrTimeout) || calls != 2 {
+		t.Fatalf("exec = %v after %d attempts, want timeout after 2", err, calls)
+	}
+}
+
+func TestExecContextDeadline(t *testing.T) {
+	m := newTestModbus(&memDriver{})
+	m.SetTimeout(time.Second)
+	m.SetRetryPolicy(DefaultRetryPolicy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := m.ExecContext(ctx, 1, readRequest(0, 1))
+	if !errors.Is(err, ErrTimeout) && !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("exec = %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("exec took %v, the context deadline was 30ms", elapsed)
+	}
+}
@@ -0,0 +1,400 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type CoilHandler interface {
+	ReadCoils(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]bool, error)
+	WriteCoils(ctx context.Context, unit uint8, address uint16, values []bool) error
+}
+
+type DiscreteInputHandler interface {
+	ReadDiscreteInputs(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]bool, error)
+}
+
+type HoldingRegisterHandler interface {
+	ReadHoldingRegisters(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]uint16, error)
+	WriteHoldingRegisters(ctx context.Context, unit uint8, address uint16, values []uint16) error
+}
+
+type InputRegisterHandler interface {
+	ReadInputRegisters(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]uint16, error)
+}
+
+type MaskWriteHandler interface {
+	MaskWriteRegister(ctx context.Context, unit uint8, address uint16, and uint16, or uint16) error
+}
+
+type ReadWriteHandler interface {
+	ReadWriteMultipleRegisters(ctx context.Context, unit uint8, readAddress uint16, readQuantity uint16, writeAddress uint16, values []uint16) ([]uint16, error)
+}
+
+type Server struct {
+	driver   Driver
+	parser   Parser
+	unit     uint8
+	coils    CoilHandler
+	discrete DiscreteInputHandler
+	holding  HoldingRegisterHandler
+	input    InputRegisterHandler
+}
+
+func NewServer(driver Driver, parser Parser, unit uint8) *Server {
+	return &Server{
+		driver: driver,
+		parser: parser,
+		unit:   unit,
+	}
+}
+
+func (s *Server) SetCoilHandler(h CoilHandler) {
+	s.coils = h
+}
+
+func (s *Server) SetDiscreteInputHandler(h DiscreteInputHandler) {
+	s.discrete = h
+}
+
+func (s *Server) SetHoldingRegisterHandler(h HoldingRegisterHandler) {
+	s.holding = h
+}
+
+func (s *Server) SetInputRegisterHandler(h InputRegisterHandler) {
+	s.input = h
+}
+
+func (s *Server) Serve(ctx context.Context) error {
+	_, t35 := silence(s.driver, s.parser)
+	in := NewBuffer(FRAME_MAX)
+	out := NewBuffer(FRAME_MAX)
+	idle := time.Now()
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if drv, ok := s.driver.(timeoutDriver); ok {
+			if !in.IsEmpty() && t35 > 0 {
+				drv.SetReadTimeout(t35)
+			} else {
+				drv.SetReadTimeout(POLL_INTERVAL)
+			}
+		}
+
+		n, err := in.ReadFrom(s.driver)
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			if !in.IsEmpty() && t35 > 0 && time.Since(idle) >= t35 {
+				if err := s.reject(in, out); err != nil {
+					return err
+				}
+				in.Skip(in.Length())
+			}
+			continue
+		}
+		idle = time.Now()
+
+		if err := s.process(ctx, in, out, t35 > 0); err != nil {
+			return err
+		}
+	}
+}
+
+// process handles every complete request in in. When silent is set the line
+// marks the end of each frame, so a request the parser cannot delimit is left
+// for reject.
+func (s *Server) process(ctx context.Context, in Buffer, out Buffer, silent bool) error {
+	_, tcp := s.parser.(*_tcpParser)
+	fn, _ := s.parser.(functionParser)
+
+	for {
+		if silent && fn != nil && fn.unknownFunction(in) {
+			return nil
+		}
+
+		addr := uint8(0)
+		req := &Request{}
+
+		err := s.parser.DecodeRequest(&addr, req, in)
+		if err == ErrChecksum {
+			continue
+		}
+
+		if err == ErrShortFrame {
+			if in.IsFull() {
+				in.Skip(1)
+				continue
+			}
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		broadcast := addr == BROADCAST && !tcp
+		if s.unit != 0 && addr != s.unit && !broadcast {
+			continue
+		}
+
+		rep := s.handle(ctx, addr, req)
+		if broadcast {
+			continue
+		}
+
+		if err := s.parser.EncodeReply(&addr, rep, out); err != nil {
+			out.Skip(out.Length())
+			continue
+		}
+
+		if _, err := out.WriteTo(s.driver); err != nil {
+			return err
+		}
+	}
+}
+
+// reject answers a frame with a function code the parser does not know, once
+// the silence after it shows the frame is complete.
+func (s *Server) reject(in Buffer, out Buffer) error {
+	fn, ok := s.parser.(functionParser)
+	if !ok {
+		return nil
+	}
+
+	addr := uint8(0)
+	opcode := uint8(0)
+	if fn.decodeFunction(&addr, &opcode, in) != nil {
+		return nil
+	}
+
+	if addr == BROADCAST || (s.unit != 0 && addr != s.unit) {
+		return nil
+	}
+
+	rep := &Reply{
+		opcode:   opcode | OPCODE_ERROR_MASK,
+		lenOrVal: EXCEPTION_ILLEGAL_FUNCTION,
+	}
+
+	if err := s.parser.EncodeReply(&addr, rep, out); err != nil {
+		out.Skip(out.Length())
+		return nil
+	}
+
+	_, err := out.WriteTo(s.driver)
+	return err
+}
+
+func exceptionCode(err error) uint8 {
+	var exc *ExceptionError
+	if errors.As(err, &exc) {
+		return exc.Code
+	}
+
+	switch {
+	case errors.Is(err, ErrAddress):
+		return EXCEPTION_ILLEGAL_DATA_ADDRESS
+	case errors.Is(err, ErrQuantity), errors.Is(err, ErrValue):
+		return EXCEPTION_ILLEGAL_DATA_VALUE
+	case errors.Is(err, ErrTimeout):
+		return EXCEPTION_GATEWAY_TARGET_NO_REPLY
+	}
+
+	return EXCEPTION_SLAVE_DEVICE_FAILURE
+}
+
+func exception(code uint8) error {
+	return &ExceptionError{Code: code}
+}
+
+func (s *Server) handle(ctx context.Context, unit uint8, req *Request) *Reply {
+	rep := &Reply{
+		Address:  req.Address,
+		opcode:   req.opcode,
+		lenOrVal: req.lenOrVal,
+	}
+
+	if err := s.dispatch(ctx, unit, req, rep); err != nil {
+		rep.opcode = req.opcode | OPCODE_ERROR_MASK
+		rep.lenOrVal = uint16(exceptionCode(err))
+		rep.payload = nil
+	}
+
+	return rep
+}
+
+func (s *Server) dispatch(ctx context.Context, unit uint8, req *Request, rep *Reply) error {
+	if !s.supports(req) {
+		return exception(EXCEPTION_ILLEGAL_FUNCTION)
+	}
+
+	if err := req.validate(); err != nil {
+		return err
+	}
+
+	switch req.opcode {
+	case OPCODE_READ_COILS:
+		values, err := s.coils.ReadCoils(ctx, unit, req.Address, req.lenOrVal)
+		return replyBits(rep, values, req.lenOrVal, err)
+	case OPCODE_DISCRETE_INPUTS:
+		values, err := s.discrete.ReadDiscreteInputs(ctx, unit, req.Address, req.lenOrVal)
+		return replyBits(rep, values, req.lenOrVal, err)
+	case OPCODE_READ_HOLDING_REGISTERS:
+		values, err := s.holding.ReadHoldingRegisters(ctx, unit, req.Address, req.lenOrVal)
+		return replyRegisters(rep, values, req.lenOrVal, err)
+	case OPCODE_READ_INPUT_REGISTERS:
+		values, err := s.input.ReadInputRegisters(ctx, unit, req.Address, req.lenOrVal)
+		return replyRegisters(rep, values, req.lenOrVal, err)
+	case OPCODE_WRITE_COIL:
+		if req.lenOrVal != 0x0000 && req.lenOrVal != 0xFF00 {
+			return ErrValue
+		}
+		return s.coils.WriteCoils(ctx, unit, req.Address, []bool{req.lenOrVal == 0xFF00})
+	case OPCODE_WRITE_REGISTER:
+		return s.holding.WriteHoldingRegisters(ctx, unit, req.Address, []uint16{req.lenOrVal})
+	case OPCODE_WRITE_COILS:
+		values, err := requestBits(req, req.lenOrVal)
+		if err != nil {
+			return err
+		}
+		return s.coils.WriteCoils(ctx, unit, req.Address, values)
+	case OPCODE_WRITE_REGISTERS:
+		values, err := requestRegisters(req, req.lenOrVal)
+		if err != nil {
+			return err
+		}
+		return s.holding.WriteHoldingRegisters(ctx, unit, req.Address, values)
+	case OPCODE_MASK_WRITE_REGISTER:
+		rep.andMask, rep.orMask = req.andMask, req.orMask
+		if h, ok := s.holding.(MaskWriteHandler); ok {
+			return h.MaskWriteRegister(ctx, unit, req.Address, req.andMask, req.orMask)
+		}
+
+		values, err := s.holding.ReadHoldingRegisters(ctx, unit, req.Address, 1)
+		if err != nil {
+			return err
+		}
+
+		if len(values) != 1 {
+			return ErrUnexpected
+		}
+
+		val := (values[0] & req.andMask) | (req.orMask &^ req.andMask)
+		return s.holding.WriteHoldingRegisters(ctx, unit, req.Address, []uint16{val})
+	case OPCODE_READ_WRITE_REGISTERS:
+		values, err := requestRegisters(req, req.writeLen)
+		if err != nil {
+			return err
+		}
+
+		if h, ok := s.holding.(ReadWriteHandler); ok {
+			values, err = h.ReadWriteMultipleRegisters(ctx, unit, req.Address, req.lenOrVal, req.WriteAddress, values)
+			return replyRegisters(rep, values, req.lenOrVal, err)
+		}
+
+		if err := s.holding.WriteHoldingRegisters(ctx, unit, req.WriteAddress, values); err != nil {
+			return err
+		}
+
+		values, err = s.holding.ReadHoldingRegisters(ctx, unit, req.Address, req.lenOrVal)
+		return replyRegisters(rep, values, req.lenOrVal, err)
+	case OPCODE_DIAGNOSTICS:
+		return nil
+	}
+
+	return exception(EXCEPTION_ILLEGAL_FUNCTION)
+}
+
+func (s *Server) supports(req *Request) bool {
+	switch req.opcode {
+	case OPCODE_READ_COILS, OPCODE_WRITE_COIL, OPCODE_WRITE_COILS:
+		return s.coils != nil
+	case OPCODE_DISCRETE_INPUTS:
+		return s.discrete != nil
+	case OPCODE_READ_HOLDING_REGISTERS, OPCODE_WRITE_REGISTER, OPCODE_WRITE_REGISTERS:
+		return s.holding != nil
+	case OPCODE_MASK_WRITE_REGISTER, OPCODE_READ_WRITE_REGISTERS:
+		return s.holding != nil
+	case OPCODE_READ_INPUT_REGISTERS:
+		return s.input != nil
+	case OPCODE_DIAGNOSTICS:
+		return req.Address == DIAG_RETURN_QUERY_DATA
+	}
+
+	return false
+}
+
+func requestBits(req *Request, quantity uint16) ([]bool, error) {
+	pyd, ok := req.payload.(*_payloadBit)
+	if !ok || len(pyd.raws) != (int(quantity)+7)/8 {
+		return nil, ErrValue
+	}
+
+	values := make([]bool, quantity)
+	for i := range values {
+		values[i] = pyd.Get(i)
+	}
+
+	return values, nil
+}
+
+func requestRegisters(req *Request, quantity uint16) ([]uint16, error) {
+	pyd, ok := req.payload.(*_payloadU16)
+	if !ok || len(pyd.raws) != int(quantity)*2 {
+		return nil, ErrValue
+	}
+
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = pyd.Get(i)
+	}
+
+	return values, nil
+}
+
+func replyBits(rep *Reply, values []bool, quantity uint16, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if len(values) != int(quantity) {
+		return ErrUnexpected
+	}
+
+	pyd := &_payloadBit{}
+	pyd.SetLength(int(quantity))
+	for i, v := range values {
+		pyd.Set(i, v)
+	}
+
+	rep.lenOrVal = uint16(len(pyd.raws))
+	rep.payload = pyd
+	return nil
+}
+
+func replyRegisters(rep *Reply, values []uint16, quantity uint16, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if len(values) != int(quantity) {
+		return ErrUnexpected
+	}
+
+	pyd := &_payloadU16{}
+	pyd.SetLength(int(quantity))
+	for i, v := range values {
+		pyd.Set(i, v)
+	}
+
+	rep.lenOrVal = uint16(len(pyd.raws))
+	rep.payload = pyd
+	return nil
+}
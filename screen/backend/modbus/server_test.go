@@ -0,0 +1,153 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serve runs a unit 1 server on d until it has sent want replies or the
+// wait is over, and returns what it sent.
+func serve(t *testing.T, d *memDriver, want int) [][]byte {
+	t.Helper()
+
+	store := NewDataStore(0, 0, 4, 0)
+	store.WriteHoldingRegisters(context.Background(), 1, 0, []uint16{5, 6, 7, 8})
+
+	srv := NewServer(d, NewRTUParser(), 1)
+	srv.SetHoldingRegisterHandler(store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Serve(ctx) }()
+
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for len(sentFrames(d)) < want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if want == 0 {
+		time.Sleep(5 * usbLatency)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	return sentFrames(d)
+}
+
+func TestServerReplies(t *testing.T) {
+	unknown := rtuFrame(0x01, 0x18, 0x00, 0x04)
+	corrupt := append([]byte{}, unknown...)
+	corrupt[3] ^= 0xFF
+
+	// quiet separates frames by more than t3.5; a shorter gap splits one.
+	quiet := 3 * usbLatency
+
+	tests := []struct {
+		name string
+		in   [][]byte
+		gap  time.Duration
+		want [][]byte
+	}{
+		{"read", [][]byte{rtuFrame(0x01, 0x03, 0x00, 0x00, 0x00, 0x02)}, quiet, [][]byte{rtuFrame(0x01, 0x03, 0x04, 0x00, 0x05, 0x00, 0x06)}},
+		{"illegal address", [][]byte{rtuFrame(0x01, 0x03, 0x00, 0x03, 0x00, 0x02)}, quiet, [][]byte{rtuFrame(0x01, 0x83, 0x02)}},
+		{"illegal quantity", [][]byte{rtuFrame(0x01, 0x03, 0x00, 0x00, 0x00, 0x00)}, quiet, [][]byte{rtuFrame(0x01, 0x83, 0x03)}},
+		{"no handler", [][]byte{rtuFrame(0x01, 0x01, 0x00, 0x00, 0x00, 0x01)}, quiet, [][]byte{rtuFrame(0x01, 0x81, 0x01)}},
+		{"write echo", [][]byte{rtuFrame(0x01, 0x06, 0x00, 0x00, 0x00, 0x09)}, quiet, [][]byte{rtuFrame(0x01, 0x06, 0x00, 0x00, 0x00, 0x09)}},
+		{"mask write", [][]byte{
+			rtuFrame(0x01, 0x16, 0x00, 0x00, 0xFF, 0xF0, 0x00, 0x03),
+			rtuFrame(0x01, 0x03, 0x00, 0x00, 0x00, 0x01),
+		}, quiet, [][]byte{
+			rtuFrame(0x01, 0x16, 0x00, 0x00, 0xFF, 0xF0, 0x00, 0x03),
+			rtuFrame(0x01, 0x03, 0x02, 0x00, 0x03),
+		}},
+		{"mask write illegal address", [][]byte{rtuFrame(0x01, 0x16, 0x00, 0x04, 0xFF, 0xF0, 0x00, 0x03)}, quiet, [][]byte{rtuFrame(0x01, 0x96, 0x02)}},
+		{"unknown function", [][]byte{unknown}, quiet, [][]byte{rtuFrame(0x01, 0x98, 0x01)}},
+		{"unknown function no data", [][]byte{rtuFrame(0x01, 0x11)}, quiet, [][]byte{rtuFrame(0x01, 0x91, 0x01)}},
+		{"unknown function split", [][]byte{unknown[:2], unknown[2:]}, time.Millisecond, [][]byte{rtuFrame(0x01, 0x98, 0x01)}},
+		{"unknown then read", [][]byte{unknown, rtuFrame(0x01, 0x03, 0x00, 0x03, 0x00, 0x01)}, quiet, [][]byte{
+			rtuFrame(0x01, 0x98, 0x01),
+			rtuFrame(0x01, 0x03, 0x02, 0x00, 0x08),
+		}},
+		{"unknown function corrupt", [][]byte{corrupt}, quiet, nil},
+		{"unknown function other unit", [][]byte{rtuFrame(0x02, 0x18, 0x00, 0x04)}, quiet, nil},
+		{"unknown function broadcast", [][]byte{rtuFrame(0x00, 0x18, 0x00, 0x04)}, quiet, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &memDriver{baud: 115200, timeout: POLL_INTERVAL, gap: tt.gap, floor: usbLatency}
+			d.queue(tt.in...)
+
+			sent := serve(t, d, len(tt.want))
+			if len(sent) != len(tt.want) {
+				t.Fatalf("sent %d replies, want %d: % x", len(sent), len(tt.want), sent)
+			}
+
+			for i := range tt.want {
+				if !bytes.Equal(sent[i], tt.want[i]) {
+					t.Fatalf("reply %d = % x, want % x", i, sent[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExceptionCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want uint8
+	}{
+		{&ExceptionError{Code: EXCEPTION_SLAVE_DEVICE_BUSY}, EXCEPTION_SLAVE_DEVICE_BUSY},
+		{fmt.Errorf("store: %w", &ExceptionError{Code: EXCEPTION_ILLEGAL_FUNCTION}), EXCEPTION_ILLEGAL_FUNCTION},
+		{ErrAddress, EXCEPTION_ILLEGAL_DATA_ADDRESS},
+		{ErrQuantity, EXCEPTION_ILLEGAL_DATA_VALUE},
+		{fmt.Errorf("store: %w", ErrValue), EXCEPTION_ILLEGAL_DATA_VALUE},
+		{ErrTimeout, EXCEPTION_GATEWAY_TARGET_NO_REPLY},
+		{errors.New("disk on fire"), EXCEPTION_SLAVE_DEVICE_FAILURE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := exceptionCode(tt.err); got != tt.want {
+				t.Fatalf("exceptionCode = 0x%02x, want 0x%02x", got, tt.want)
+			}
+		})
+	}
+}
+
+// Every writer sets its own bit. Masks applied as a separate read and write
+// would lose some of them.
+func TestDataStoreMaskWrite(t *testing.T) {
+	store := NewDataStore(0, 0, 1, 0)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for bit := 0; bit < 16; bit++ {
+		wg.Add(1)
+		go func(mask uint16) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				store.MaskWriteRegister(ctx, 1, 0, 0xFFFF&^mask, 0)
+				store.MaskWriteRegister(ctx, 1, 0, 0xFFFF&^mask, mask)
+			}
+		}(1 << bit)
+	}
+	wg.Wait()
+
+	values, err := store.ReadHoldingRegisters(ctx, 1, 0, 1)
+	if err != nil || values[0] != 0xFFFF {
+		t.Fatalf("register = %04x, %v, want ffff", values, err)
+	}
+
+	if err := store.MaskWriteRegister(ctx, 1, 1, 0, 0); !errors.Is(err, ErrAddress) {
+		t.Fatalf("out of range: %v", err)
+	}
+}
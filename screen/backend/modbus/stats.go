@@ -0,0 +1,186 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+}
+
+// Histogram counts latencies per bucket of LatencyBuckets. Counts has one
+// extra slot for everything above the last bound.
+type Histogram struct {
+	Counts []uint64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+
+	h.Counts[i]++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+func (h *Histogram) Count() uint64 {
+	n := uint64(0)
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+func (h *Histogram) Mean() time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(n)
+}
+
+// Percentile returns the upper bound of the bucket holding the p-th
+// percentile, or Max when it falls above the last bucket.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+
+	rank := uint64(p*float64(n) + 0.5)
+	seen := uint64(0)
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank && i < len(LatencyBuckets) {
+			return LatencyBuckets[i]
+		}
+	}
+	return h.Max
+}
+
+// UnitStats are the link counters of one unit. Skipped counts the bytes
+// thrown away while resyncing on corrupt or incomplete frames.
+type UnitStats struct {
+	Requests   uint64
+	Replies    uint64
+	Timeouts   uint64
+	Checksums  uint64
+	Skipped    uint64
+	Exceptions uint64
+	Errors     uint64
+	Latency    Histogram
+}
+
+func (s *UnitStats) String() string {
+	return fmt.Sprintf("requests(%d) replies(%d) timeouts(%d) checksums(%d) skipped(%d) exceptions(%d) errors(%d) latency(mean %v p95 %v max %v)",
+		s.Requests, s.Replies, s.Timeouts, s.Checksums, s.Skipped, s.Exceptions, s.Errors,
+		s.Latency.Mean(), s.Latency.Percentile(0.95), s.Latency.Max)
+}
+
+func (s *UnitStats) clone() UnitStats {
+	c := *s
+	c.Latency.Counts = append([]uint64(nil), s.Latency.Counts...)
+	return c
+}
+
+// _tally collects what happened on the wire during one transaction, so it
+// can be folded into the stats once the outcome is known.
+type _tally struct {
+	checksums uint64
+	skipped   uint64
+}
+
+type _stats struct {
+	mu    sync.Mutex
+	units map[uint8]*UnitStats
+}
+
+func isException(err error) bool {
+	var exc *ExceptionError
+	return errors.As(err, &exc)
+}
+
+func (s *_stats) unit(unit uint8) *UnitStats {
+	if s.units == nil {
+		s.units = make(map[uint8]*UnitStats)
+	}
+
+	u, ok := s.units[unit]
+	if !ok {
+		u = &UnitStats{}
+		s.units[unit] = u
+	}
+	return u
+}
+
+// sent records a request that expects no reply, such as a broadcast.
+func (s *_stats) sent(unit uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unit(unit).Requests++
+}
+
+func (s *_stats) record(unit uint8, t *_tally, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.unit(unit)
+	u.Requests++
+	u.Checksums += t.checksums
+	u.Skipped += t.skipped
+
+	switch {
+	case err == nil:
+		u.Replies++
+		u.Latency.observe(latency)
+	case isException(err):
+		u.Replies++
+		u.Exceptions++
+		u.Latency.observe(latency)
+	case errors.Is(err, ErrTimeout):
+		u.Timeouts++
+	case errors.Is(err, ErrChecksum), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+	default:
+		u.Errors++
+	}
+}
+
+func (m *Modbus) Stats() map[uint8]UnitStats {
+	m.stats.mu.Lock()
+	defer m.stats.mu.Unlock()
+
+	units := make(map[uint8]UnitStats, len(m.stats.units))
+	for unit, s := range m.stats.units {
+		units[unit] = s.clone()
+	}
+	return units
+}
+
+func (m *Modbus) ResetStats() {
+	m.stats.mu.Lock()
+	defer m.stats.mu.Unlock()
+
+	m.stats.units = nil
+}
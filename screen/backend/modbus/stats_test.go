@@ -0,0 +1,117 @@
+package modbus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := Histogram{}
+	if h.Count() != 0 || h.Mean() != 0 || h.Percentile(0.5) != 0 {
+		t.Fatalf("empty histogram = %+v", h)
+	}
+
+	for _, d := range []time.Duration{
+		1 * time.Millisecond,
+		7 * time.Millisecond,
+		7 * time.Millisecond,
+		30 * time.Millisecond,
+		2 * time.Second,
+	} {
+		h.observe(d)
+	}
+
+	want := []uint64{1, 2, 0, 1, 0, 0, 0, 0, 1}
+	for i, c := range want {
+		if h.Counts[i] != c {
+			t.Fatalf("counts = %v, want %v", h.Counts, want)
+		}
+	}
+
+	if h.Count() != 5 || h.Max != 2*time.Second || h.Mean() != 409*time.Millisecond {
+		t.Fatalf("count %d max %v mean %v", h.Count(), h.Max, h.Mean())
+	}
+
+	percentiles := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0.2, 5 * time.Millisecond},
+		{0.5, 10 * time.Millisecond},
+		{0.8, 50 * time.Millisecond},
+		{0.95, 2 * time.Second},
+	}
+
+	for _, tt := range percentiles {
+		if got := h.Percentile(tt.p); got != tt.want {
+			t.Fatalf("percentile %v = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestStatsCounters(t *testing.T) {
+	good := rtuFrame(0x01, 0x03, 0x02, 0x00, 0x05)
+	corrupt := append([]byte{}, good...)
+	corrupt[4] ^= 0xFF
+
+	tests := []struct {
+		name  string
+		unit  uint8
+		reply [][]byte
+		want  UnitStats
+	}{
+		{"reply", 1, [][]byte{good}, UnitStats{Requests: 1, Replies: 1}},
+		{"exception", 1, [][]byte{rtuFrame(0x01, 0x83, 0x02)}, UnitStats{Requests: 1, Replies: 1, Exceptions: 1}},
+		{"timeout", 1, nil, UnitStats{Requests: 1, Timeouts: 1}},
+		{"noise then reply", 1, [][]byte{append([]byte{0x01, 0x83, 0x02, 0x00}, good...)}, UnitStats{Requests: 1, Replies: 1, Checksums: 1, Skipped: 4}},
+		// The tail of the corrupt frame parses as the start of a longer one
+		// and is still waiting for more bytes when the request times out.
+		{"corrupt", 1, [][]byte{corrupt}, UnitStats{Requests: 1, Checksums: 1, Skipped: 3}},
+		{"other unit", 1, [][]byte{rtuFrame(0x02, 0x03, 0x02, 0x00, 0x05)}, UnitStats{Requests: 1, Errors: 1}},
+		{"broadcast", BROADCAST, nil, UnitStats{Requests: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModbus(&memDriver{respond: reply(tt.reply...)})
+
+			if tt.unit == BROADCAST {
+				m.WriteSingleRegister(context.Background(), tt.unit, 0, 1)
+			} else {
+				m.Exec(tt.unit, readRequest(0, 1))
+			}
+
+			got, ok := m.Stats()[tt.unit]
+			if !ok {
+				t.Fatalf("no stats for unit %d", tt.unit)
+			}
+
+			if got.Latency.Count() != tt.want.Replies {
+				t.Fatalf("observed %d latencies, want one per reply", got.Latency.Count())
+			}
+
+			got.Latency = Histogram{}
+			if got.String() != tt.want.String() {
+				t.Fatalf("stats = %s, want %s", got.String(), tt.want.String())
+			}
+		})
+	}
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	m := newTestModbus(&memDriver{respond: reply(rtuFrame(0x01, 0x03, 0x02, 0x00, 0x05))})
+	m.Exec(1, readRequest(0, 1))
+
+	snap := m.Stats()[1]
+	m.Exec(1, readRequest(0, 1))
+
+	if snap.Requests != 1 || snap.Latency.Count() != 1 {
+		t.Fatalf("snapshot changed under the caller: %+v", snap)
+	}
+
+	m.ResetStats()
+	if len(m.Stats()) != 0 {
+		t.Fatalf("stats after reset = %v", m.Stats())
+	}
+}
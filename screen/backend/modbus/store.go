@@ -0,0 +1,144 @@
+package modbus
+
+import (
+	"context"
+	"sync"
+)
+
+type DataStore struct {
+	mu       sync.RWMutex
+	coils    []bool
+	discrete []bool
+	holding  []uint16
+	input    []uint16
+}
+
+func NewDataStore(coils int, discrete int, holding int, input int) *DataStore {
+	return &DataStore{
+		coils:    make([]bool, coils),
+		discrete: make([]bool, discrete),
+		holding:  make([]uint16, holding),
+		input:    make([]uint16, input),
+	}
+}
+
+func storeRange(size int, address uint16, quantity int) error {
+	if int(address)+quantity > size {
+		return ErrAddress
+	}
+
+	return nil
+}
+
+func (d *DataStore) ReadCoils(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if err := storeRange(len(d.coils), address, int(quantity)); err != nil {
+		return nil, err
+	}
+
+	return append([]bool(nil), d.coils[address:int(address)+int(quantity)]...), nil
+}
+
+func (d *DataStore) WriteCoils(ctx context.Context, unit uint8, address uint16, values []bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := storeRange(len(d.coils), address, len(values)); err != nil {
+		return err
+	}
+
+	copy(d.coils[address:], values)
+	return nil
+}
+
+func (d *DataStore) ReadDiscreteInputs(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if err := storeRange(len(d.discrete), address, int(quantity)); err != nil {
+		return nil, err
+	}
+
+	return append([]bool(nil), d.discrete[address:int(address)+int(quantity)]...), nil
+}
+
+func (d *DataStore) SetDiscreteInputs(address uint16, values []bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := storeRange(len(d.discrete), address, len(values)); err != nil {
+		return err
+	}
+
+	copy(d.discrete[address:], values)
+	return nil
+}
+
+func (d *DataStore) ReadHoldingRegisters(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]uint16, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if err := storeRange(len(d.holding), address, int(quantity)); err != nil {
+		return nil, err
+	}
+
+	return append([]uint16(nil), d.holding[address:int(address)+int(quantity)]...), nil
+}
+
+func (d *DataStore) WriteHoldingRegisters(ctx context.Context, unit uint8, address uint16, values []uint16) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := storeRange(len(d.holding), address, len(values)); err != nil {
+		return err
+	}
+
+	copy(d.holding[address:], values)
+	return nil
+}
+
+// MaskWriteRegister applies the masks under the lock, so a write from
+// another client can not land between the read and the write.
+func (d *DataStore) MaskWriteRegister(ctx context.Context, unit uint8, address uint16, and uint16, or uint16) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := storeRange(len(d.holding), address, 1); err != nil {
+		return err
+	}
+
+	d.holding[address] = (d.holding[address] & and) | (or &^ and)
+	return nil
+}
+
+func (d *DataStore) ReadInputRegisters(ctx context.Context, unit uint8, address uint16, quantity uint16) ([]uint16, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if err := storeRange(len(d.input), address, int(quantity)); err != nil {
+		return nil, err
+	}
+
+	return append([]uint16(nil), d.input[address:int(address)+int(quantity)]...), nil
+}
+
+func (d *DataStore) SetInputRegisters(address uint16, values []uint16) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := storeRange(len(d.input), address, len(values)); err != nil {
+		return err
+	}
+
+	copy(d.input[address:], values)
+	return nil
+}
+
+func (d *DataStore) Attach(s *Server) {
+	s.SetCoilHandler(d)
+	s.SetDiscreteInputHandler(d)
+	s.SetHoldingRegisterHandler(d)
+	s.SetInputRegisterHandler(d)
+}
@@ -2,68 +2,227 @@ package monitor
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
-	"github.com/coorify/be/device"
 	"github.com/coorify/be/modbus"
 	"github.com/coorify/be/openwrt"
+	"github.com/coorify/be/option"
+	"github.com/sirupsen/logrus"
 )
 
+var metrics = map[string]func(sys *openwrt.SystemStatus, sta *openwrt.NetworkStatus) uint16{
+	"cpu":  func(sys *openwrt.SystemStatus, sta *openwrt.NetworkStatus) uint16 { return sys.Cpu },
+	"mem":  func(sys *openwrt.SystemStatus, sta *openwrt.NetworkStatus) uint16 { return sys.Mem },
+	"tmp":  func(sys *openwrt.SystemStatus, sta *openwrt.NetworkStatus) uint16 { return sys.Tmp },
+	"up":   func(sys *openwrt.SystemStatus, sta *openwrt.NetworkStatus) uint16 { return sta.Up },
+	"down": func(sys *openwrt.SystemStatus, sta *openwrt.NetworkStatus) uint16 { return sta.Down },
+	"num":  func(sys *openwrt.SystemStatus, sta *openwrt.NetworkStatus) uint16 { return sta.Num },
+}
+
+var DefaultScreen = option.ScreenOption{
+	Name:    "screen",
+	Unit:    1,
+	Address: 1,
+	Metrics: []string{"cpu", "mem", "tmp", "up", "down", "num"},
+}
+
 type Monitor struct {
-	wrt  openwrt.Client
-	mdb  *modbus.Modbus
-	exit context.CancelFunc
+	wrt     openwrt.Client
+	mdb     *modbus.Modbus
+	screens []option.ScreenOption
+	clock   uint16
+	exit    context.CancelFunc
+	done    chan struct{}
 }
 
-func NewMonitor(drv *device.Driver, wrt openwrt.Client) *Monitor {
+func NewMonitor(mdb *modbus.Modbus, wrt openwrt.Client, o *option.MonitorOption) *Monitor {
+	// validate fills in defaults, which must not reach the caller's options.
+	screens := append([]option.ScreenOption(nil), o.Screens...)
+	if len(screens) == 0 {
+		screens = []option.ScreenOption{DefaultScreen}
+	}
+
+	for i := range screens {
+		screens[i].Metrics = append([]string(nil), screens[i].Metrics...)
+	}
+
 	return &Monitor{
-		wrt: wrt,
-		mdb: modbus.New(drv, modbus.NewRTUParser()),
+		wrt:     wrt,
+		mdb:     mdb,
+		screens: screens,
+		clock:   o.Clock,
 	}
 }
 
+func (m *Monitor) validate() error {
+	for i := range m.screens {
+		s := &m.screens[i]
+		if s.Unit == modbus.BROADCAST {
+			return fmt.Errorf("monitor: screen %q: unit must not be the broadcast address", s.Name)
+		}
+
+		if len(s.Metrics) == 0 {
+			s.Metrics = append([]string(nil), DefaultScreen.Metrics...)
+		}
+
+		for _, name := range s.Metrics {
+			if _, ok := metrics[name]; !ok {
+				return fmt.Errorf("monitor: screen %q: unknown metric %q", s.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// identify logs what a screen reports about itself and how well its link
+// echoes. A screen that does not answer at all is not probed any further.
+func (m *Monitor) identify(ctx context.Context, s *option.ScreenOption) {
+	id, err := m.mdb.ReadDeviceIdentification(ctx, s.Unit, modbus.DEVICE_ID_EXTENDED)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		logrus.Warnf("monitor: %s: device identification: %v", s.Name, err)
+		if errors.Is(err, modbus.ErrTimeout) {
+			return
+		}
+	} else {
+		logrus.Infof("monitor: %s: device vendor(%s) product(%s) revision(%s)", s.Name,
+			id.Get(modbus.OBJECT_VENDOR_NAME), id.Get(modbus.OBJECT_PRODUCT_CODE), id.Get(modbus.OBJECT_REVISION))
+		logrus.Infof("monitor: %s: device serial(%s) mac(%s)", s.Name,
+			id.Get(modbus.OBJECT_SERIAL_NUMBER), id.Get(modbus.OBJECT_MAC_ADDRESS))
+	}
+
+	probes := 4
+	passed := 0
+	for i := 0; i < probes; i++ {
+		err := m.mdb.Echo(ctx, s.Unit, uint16(0xA5A0+i))
+		if ctx.Err() != nil {
+			return
+		}
+
+		var exc *modbus.ExceptionError
+		if errors.As(err, &exc) {
+			logrus.Warnf("monitor: %s: link check: %v", s.Name, err)
+			return
+		}
+
+		if errors.Is(err, modbus.ErrTimeout) {
+			break
+		}
+
+		if err == nil {
+			passed++
+		}
+	}
+	logrus.Infof("monitor: %s: link check %d/%d echoes", s.Name, passed, probes)
+}
+
 func (m *Monitor) run(ctx context.Context) {
+	defer close(m.done)
+
+	// Identification only feeds the log, so it must not hold up the first
+	// metrics tick; it runs beside the loop at low priority.
+	idents := sync.WaitGroup{}
+	defer idents.Wait()
+
+	idents.Add(1)
+	go func() {
+		defer idents.Done()
+
+		low := modbus.WithPriority(ctx, modbus.PriorityLow)
+		for i := range m.screens {
+			m.identify(low, &m.screens[i])
+		}
+	}()
+
+	synced := time.Time{}
+	reported := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-time.After(2 * time.Second):
-			m.metrics()
+			low := modbus.WithPriority(ctx, modbus.PriorityLow)
+			if m.clock != 0 && time.Since(synced) >= time.Minute {
+				m.sync(low)
+				synced = time.Now()
+			}
+			m.metrics(low)
+
+			if time.Since(reported) >= 5*time.Minute {
+				m.report()
+				reported = time.Now()
+			}
 		}
 	}
 }
 
-func (m *Monitor) metrics() {
-	req := modbus.NewRequest(modbus.OPCODE_WRITE_REGISTERS)
-	req.Address = 1
-	req.SetLength(6)
+func (m *Monitor) report() {
+	for unit, st := range m.mdb.Stats() {
+		logrus.Infof("monitor: unit %d: %s", unit, st.String())
+	}
+}
+
+// sync broadcasts the unix time to every screen on the bus at once.
+func (m *Monitor) sync(ctx context.Context) {
+	regs := make([]uint16, 2)
+	modbus.ABCD.PutUint32(regs, uint32(time.Now().Unix()))
 
-	pyd := req.Payload().(modbus.PayloadU16)
+	if err := m.mdb.WriteMultipleRegisters(ctx, modbus.BROADCAST, m.clock, regs); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
 
+		logrus.Warnf("monitor: sync clock: %v", err)
+	}
+}
+
+func (m *Monitor) metrics(ctx context.Context) {
 	sys, err := m.wrt.SystemStatus()
-	if err == nil {
-		pyd.Set(0, sys.Cpu)
-		pyd.Set(1, sys.Mem)
-		pyd.Set(2, sys.Tmp)
+	if err != nil {
+		sys = &openwrt.SystemStatus{}
 	}
 
 	sta, err := m.wrt.NetworkStatus()
-	if err == nil {
-		pyd.Set(3, sta.Up)
-		pyd.Set(4, sta.Down)
-		pyd.Set(5, sta.Num)
+	if err != nil {
+		sta = &openwrt.NetworkStatus{}
 	}
 
-	m.mdb.Exec(1, req)
+	for i := range m.screens {
+		s := &m.screens[i]
+		regs := make([]uint16, len(s.Metrics))
+		for j, name := range s.Metrics {
+			regs[j] = metrics[name](sys, sta)
+		}
+
+		if err := m.mdb.WriteMultipleRegisters(ctx, s.Unit, s.Address, regs); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			logrus.Warnf("monitor: %s: push metrics: %v", s.Name, err)
+		}
+	}
 }
 
 func (m *Monitor) Start() error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+
 	if err := m.mdb.Open(); err != nil {
 		return err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	m.exit = cancel
+	m.done = make(chan struct{})
 
 	go m.run(ctx)
 
@@ -72,6 +231,7 @@ func (m *Monitor) Start() error {
 
 func (m *Monitor) Stop() error {
 	m.exit()
+	<-m.done
 
 	return m.mdb.Close()
 }
@@ -0,0 +1,6 @@
+package option
+
+type CaptureOption struct {
+	Enable bool   `default:"false"`
+	File   string `default:"modbus.jsonl"`
+}
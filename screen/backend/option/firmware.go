@@ -0,0 +1,6 @@
+package option
+
+type FirmwareOption struct {
+	Backup string
+	Baud   int `default:"921600"`
+}
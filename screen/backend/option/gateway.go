@@ -0,0 +1,15 @@
+package option
+
+type GatewayRange struct {
+	Table    string `default:"holding"`
+	Start    uint16
+	End      uint16
+	ReadOnly bool
+}
+
+type GatewayOption struct {
+	Enable bool   `default:"false"`
+	Listen string `default:":502"`
+	Unit   uint8  `default:"1"`
+	Ranges []GatewayRange
+}
@@ -0,0 +1,13 @@
+package option
+
+type ScreenOption struct {
+	Name    string
+	Unit    uint8
+	Address uint16
+	Metrics []string
+}
+
+type MonitorOption struct {
+	Screens []ScreenOption
+	Clock   uint16
+}
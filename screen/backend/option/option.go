@@ -1,5 +1,9 @@
 package option
 
 type Option struct {
-	OpenWrt OpenWrtOption
+	OpenWrt  OpenWrtOption
+	Firmware FirmwareOption
+	Monitor  MonitorOption
+	Gateway  GatewayOption
+	Capture  CaptureOption
 }
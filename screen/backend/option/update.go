@@ -4,5 +4,8 @@ import "io/fs"
 
 type UpdateOption struct {
 	Version uint16
+	Unit    uint8
+	Backup  string
+	Baud    int
 	EmbedFS fs.FS
 }